@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/grglucastr/go-greenlight/internal/data"
+	"github.com/grglucastr/go-greenlight/internal/validator"
+)
+
+// Schedules the deletion of the current user. Every session and API key is
+// revoked right away, the account itself is only removed once the grace
+// period is over.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deleteAt := time.Now().Add(app.config.deletion.gracePeriod)
+
+	err = app.models.Users.ScheduleDeletion(user.ID, deleteAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"deleteAt": deleteAt.Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(user.Email, "account_deletion.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{
+		"message":   "your account is scheduled for deletion, log in again before then to cancel it",
+		"delete_at": deleteAt,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Returns everything we store about the current user in a single JSON
+// document, so it can be handed over on a data access request.
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	family, err := app.currentSessionFamily(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetAllSessionsForUser(user.ID, family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	twoFactor := false
+
+	totp, err := app.models.TOTP.GetForUser(user.ID)
+	switch {
+	case err == nil:
+		twoFactor = totp.Enabled
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	reviews, err := app.models.Reviews.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	watchlist, err := app.models.Watchlist.GetAllForExport(user.ID, exportFilters("added_at"))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	watched, err := app.models.Watched.GetAllForExport(user.ID, exportFilters("watched_at"))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	export := envelope{
+		"exported_at":        time.Now(),
+		"user":               user,
+		"permissions":        permissions,
+		"roles":              roles,
+		"sessions":           sessions,
+		"api_keys":           apiKeys,
+		"two_factor_enabled": twoFactor,
+		"reviews":            reviews,
+		"watchlist":          watchlist,
+		"watched":            watched,
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", `attachment; filename="greenlight-export.json"`)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"export": export}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Filters fitting every record of a paginated list on a single page
+func exportFilters(sort string) data.Filters {
+	return data.Filters{Page: 1, PageSize: math.MaxInt32, Sort: sort, SortSafelist: []string{sort}}
+}
+
+// Background loop removing the accounts whose grace period is over
+func (app *application) purgeDeletedUsers() {
+	for {
+		time.Sleep(app.config.deletion.purgeInterval)
+
+		deleted, err := app.models.Users.DeleteScheduled()
+		if err != nil {
+			app.logger.Error("unable to purge deleted users", "error", err.Error())
+			continue
+		}
+
+		if deleted > 0 {
+			app.logger.Info("purged deleted users", "count", deleted)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/grglucastr/go-greenlight/internal/data"
+	"github.com/grglucastr/go-greenlight/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// Records an audit event about the target, made by the user of the request.
+// Before and after are the state of the target around the change, either of
+// them may be nil. A failure to record the event is only logged, it never
+// fails the request.
+func (app *application) audit(r *http.Request, action, targetType string, targetID int64, before, after any) {
+	event := &data.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		IP:         realip.FromRequest(r),
+		RequestID:  app.contextGetRequestID(r),
+	}
+
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		event.ActorID = &user.ID
+	}
+
+	if targetID != 0 {
+		event.TargetID = &targetID
+	}
+
+	err := event.SetDiff(before, after)
+	if err == nil {
+		err = app.models.Audit.Insert(event)
+	}
+
+	if err != nil {
+		app.logger.Error("unable to record audit event", "error", err.Error(), "action", action, "request_id", event.RequestID)
+	}
+}
+
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilters
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	if qs.Has("actor_id") {
+		id := int64(app.readInt(qs, "actor_id", 0, v))
+		input.ActorID = &id
+	}
+
+	if qs.Has("target_id") {
+		id := int64(app.readInt(qs, "target_id", 0, v))
+		input.TargetID = &id
+	}
+
+	input.Action = app.readString(qs, "action", "")
+	input.TargetType = app.readString(qs, "target_type", "")
+	input.RequestID = app.readString(qs, "request_id", "")
+	input.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.CreatedBefore = app.readTime(qs, "created_before", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Newest events first unless asked otherwise
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(input.AuditFilters, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -13,6 +13,19 @@ type contextKey string
 // in the request context
 const userContextKey = contextKey("user")
 
+// Holds the plaintext bearer token that authenticated the request
+const tokenContextKey = contextKey("token")
+
+// Holds the permissions carried by the credentials themselves, when they
+// carry any, so they don't need to be loaded from the database.
+const permissionsContextKey = contextKey("permissions")
+
+// Holds the API key that authenticated the request
+const apiKeyContextKey = contextKey("apiKey")
+
+// Holds the ID the requestID() middleware gave to the request
+const requestIDContextKey = contextKey("requestID")
+
 // returns a new copy of the request with the provided
 // User struct added to the context.
 // We are using the userContextKey constant as the key.
@@ -30,3 +43,48 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// returns the plaintext token used to authenticate the request, or an
+// empty string for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// The boolean is false when the request credentials don't carry permissions
+// and no middleware has loaded them yet
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// returns nil unless the request was authenticated with an API key
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
@@ -9,7 +9,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/grglucastr/go-greenlight/internal/data"
 	"github.com/grglucastr/go-greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -146,6 +148,110 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// Reads a comma separated list of related data to embed in the response,
+// like ?include=credits. Every value must be one of the permitted ones.
+func (app *application) readInclude(qs url.Values, v *validator.Validator, permitted ...string) []string {
+	include := app.readCSV(qs, "include", []string{})
+
+	for _, value := range include {
+		v.Check(validator.PermittedValue(value, permitted...), "include", fmt.Sprintf("unknown value %q", value))
+	}
+
+	return include
+}
+
+// Returns nil when the key is missing, so callers can tell "not set" apart
+// from false.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// Accepts either a date (2006-01-02) or a full RFC 3339 timestamp. Returns nil
+// when the key is missing.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return &t
+		}
+	}
+
+	v.AddError(key, "must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+	return nil
+}
+
+// Deletes both the access and the refresh tokens of a user, so every one of
+// their sessions has to log in again.
+// Returns the permissions of the user making the request. They are loaded
+// once per request: requirePermission() keeps them in the request context.
+func (app *application) currentPermissions(r *http.Request) (data.Permissions, error) {
+	if permissions, ok := app.contextGetPermissions(r); ok {
+		return permissions, nil
+	}
+
+	return app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+}
+
+func (app *application) revokeAllSessions(userID int64) error {
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the token family of the session making the request. Requests made
+// with an API key don't belong to any session, so they get an empty family.
+func (app *application) currentSessionFamily(r *http.Request) (string, error) {
+	if app.contextGetAPIKey(r) != nil {
+		return "", nil
+	}
+
+	token := app.contextGetToken(r)
+
+	if app.config.auth.mode == authModeJWT {
+		claims, err := app.jwt.Verify(token)
+		if err != nil {
+			return "", err
+		}
+
+		return claims.SessionID, nil
+	}
+
+	return app.models.Tokens.GetFamily(data.ScopeAuthentication, token)
+}
+
+// Like revokeAllSessions(), but keeps the session making the request logged in
+func (app *application) revokeOtherSessions(r *http.Request, userID int64) error {
+	family, err := app.currentSessionFamily(r)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Tokens.DeleteOtherSessionsForUser(userID, family)
+}
+
 // This is a Go "first-class functions"
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter
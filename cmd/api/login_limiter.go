@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// loginLimiter tracks failed login attempts per key, where a key is either an
+// email address or a client IP. Once a key reaches maxAttempts failures it's
+// locked, and every further failure doubles the lockout up to maxLockout.
+type loginLimiter struct {
+	mu          sync.Mutex
+	attempts    map[string]*loginAttempts
+	maxAttempts int
+	lockout     time.Duration
+	maxLockout  time.Duration
+}
+
+type loginAttempts struct {
+	failures    int
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+func newLoginLimiter(maxAttempts int, lockout, maxLockout time.Duration) *loginLimiter {
+	l := &loginLimiter{
+		attempts:    make(map[string]*loginAttempts),
+		maxAttempts: maxAttempts,
+		lockout:     lockout,
+		maxLockout:  maxLockout,
+	}
+
+	// background goroutine which forgets the keys that haven't failed for
+	// a while, so the map doesn't grow forever.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			l.mu.Lock()
+
+			for key, a := range l.attempts {
+				if time.Since(a.lastSeen) > 2*l.maxLockout && time.Now().After(a.lockedUntil) {
+					delete(l.attempts, key)
+				}
+			}
+
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// Returns how long the caller has to wait before trying again. It's zero
+// when none of the keys is locked.
+func (l *loginLimiter) wait(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var longest time.Duration
+
+	for _, key := range keys {
+		if a, found := l.attempts[key]; found {
+			longest = max(longest, time.Until(a.lockedUntil))
+		}
+	}
+
+	return longest
+}
+
+// Records a failed attempt. It returns the lockout when this failure locked
+// the key, or zero otherwise.
+func (l *loginLimiter) fail(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, found := l.attempts[key]
+	if !found {
+		a = &loginAttempts{}
+		l.attempts[key] = a
+	}
+
+	a.failures++
+	a.lastSeen = time.Now()
+
+	if a.failures < l.maxAttempts {
+		return 0
+	}
+
+	// Exponential backoff: lockout, 2*lockout, 4*lockout... capped at maxLockout
+	lockout := l.lockout
+	for i := l.maxAttempts; i < a.failures && lockout < l.maxLockout; i++ {
+		lockout *= 2
+	}
+	lockout = min(lockout, l.maxLockout)
+
+	a.lockedUntil = time.Now().Add(lockout)
+
+	return lockout
+}
+
+func (l *loginLimiter) reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.attempts, key)
+	}
+}
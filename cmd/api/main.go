@@ -3,17 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/grglucastr/go-greenlight/internal/data"
+	"github.com/grglucastr/go-greenlight/internal/jwt"
 	"github.com/grglucastr/go-greenlight/internal/mailer"
 	"github.com/grglucastr/go-greenlight/internal/vcs"
 	_ "github.com/lib/pq"
@@ -52,16 +55,51 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	auth struct {
+		mode            string
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+		purgeInterval   time.Duration
+	}
+	jwt struct {
+		keys         []*jwt.Key
+		signingKeyID string
+	}
+	login struct {
+		maxAttempts int
+		lockout     time.Duration
+		maxLockout  time.Duration
+	}
+	totpKey     []byte
+	defaultRole string
+	inviteOnly  bool
+	deletion    struct {
+		gracePeriod   time.Duration
+		purgeInterval time.Duration
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
 }
 
+// Authentication modes. Opaque access tokens are looked up in the database on
+// every request, JWT access tokens are verified locally.
+const (
+	authModeOpaque = "opaque"
+	authModeJWT    = "jwt"
+)
+
 // Application dependency injection to be used in
 // HTTP handlers, helpers, and middleware
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer *mailer.Mailer
-	wg     sync.WaitGroup
+	config       config
+	logger       *slog.Logger
+	models       data.Models
+	mailer       *mailer.Mailer
+	jwt          *jwt.KeySet
+	loginLimiter *loginLimiter
+	wg           sync.WaitGroup
 }
 
 func main() {
@@ -94,6 +132,50 @@ func main() {
 		return nil
 	})
 
+	//flags for authentication tokens
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeOpaque, "Access token mode (opaque|jwt). JWTs are checked without the database, so revoked sessions and permission changes only apply once the access token expires")
+	flag.DurationVar(&cfg.auth.accessTokenTTL, "auth-access-token-ttl", FIFTEEN_MINUTES, "Lifetime of access tokens")
+	flag.DurationVar(&cfg.auth.refreshTokenTTL, "auth-refresh-token-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	flag.DurationVar(&cfg.auth.purgeInterval, "auth-purge-interval", time.Hour, "How often expired tokens are removed, 0 to disable")
+
+	//flags for jwt signing keys
+	flag.Func("jwt-keys", "JWT keys as space separated kid:alg:base64 triples, alg being HS256 or EdDSA", func(val string) error {
+		for _, field := range strings.Fields(val) {
+			key, err := parseJWTKey(field)
+			if err != nil {
+				return err
+			}
+			cfg.jwt.keys = append(cfg.jwt.keys, key)
+		}
+		return nil
+	})
+	flag.StringVar(&cfg.jwt.signingKeyID, "jwt-signing-key", "", "Key id used to sign new JWTs, other keys are only used to verify")
+
+	//flags for login brute-force protection
+	flag.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Failed logins per email or IP before a lockout")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", time.Minute, "First login lockout, doubled by every further failure")
+	flag.DurationVar(&cfg.login.maxLockout, "login-max-lockout", time.Hour, "Longest login lockout")
+
+	flag.Func("totp-key", "Base64 32 byte key encrypting the 2FA secrets at rest, they're stored in plaintext without it", func(val string) error {
+		key, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			return fmt.Errorf("invalid base64 for totp key")
+		}
+		cfg.totpKey = key
+		return nil
+	})
+
+	flag.StringVar(&cfg.defaultRole, "default-role", "viewer", "Role given to newly registered users, empty for none")
+	flag.BoolVar(&cfg.inviteOnly, "invite-only", false, "Only allow registering with an invitation")
+
+	//flags for account deletion
+	flag.DurationVar(&cfg.deletion.gracePeriod, "deletion-grace-period", 30*24*time.Hour, "Time before a deleted account is removed for good, logging in meanwhile cancels it")
+	flag.DurationVar(&cfg.deletion.purgeInterval, "deletion-purge-interval", time.Hour, "How often accounts past their grace period are removed, 0 to disable")
+
+	//flags for the movie trash
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "Time a deleted movie stays in the trash before it's removed for good")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often movies past the trash retention are removed, 0 to disable")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -105,6 +187,44 @@ func main() {
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	var jwtKeys *jwt.KeySet
+
+	switch cfg.auth.mode {
+	case authModeOpaque:
+	case authModeJWT:
+		var err error
+
+		jwtKeys, err = jwt.NewKeySet(cfg.jwt.signingKeyID, cfg.jwt.keys...)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	default:
+		logger.Error(fmt.Sprintf("invalid auth mode %q", cfg.auth.mode))
+		os.Exit(1)
+	}
+
+	// A zero or negative value would lock every login out, or silently turn
+	// the brute-force protection off.
+	switch {
+	case cfg.login.maxAttempts < 1:
+		logger.Error("login-max-attempts must be at least 1")
+		os.Exit(1)
+	case cfg.login.lockout <= 0:
+		logger.Error("login-lockout must be positive")
+		os.Exit(1)
+	case cfg.login.maxLockout < cfg.login.lockout:
+		logger.Error("login-max-lockout must not be shorter than login-lockout")
+		os.Exit(1)
+	}
+
+	// The purge deletes movies trashed before now minus the retention, so a
+	// zero or negative value would wipe the trash as soon as it runs
+	if cfg.trash.retention <= 0 {
+		logger.Error("trash-retention must be positive")
+		os.Exit(1)
+	}
+
 	db, err := openDB(cfg)
 
 	if err != nil {
@@ -138,11 +258,56 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	models := data.NewModels(db)
+
+	if cfg.totpKey != nil {
+		models.TOTP.Cipher, err = data.NewTOTPCipher(cfg.totpKey)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	} else {
+		logger.Warn("no -totp-key set, 2FA secrets are stored in plaintext")
+	}
+
+	expvar.Publish("permissions_cache", expvar.Func(func() any {
+		return models.Permissions.CacheStats()
+	}))
+
+	// Fail fast on a misspelled default role, otherwise new users would
+	// silently get no permissions at all.
+	if cfg.defaultRole != "" {
+		roles, err := models.Roles.ListNames()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		if !slices.Contains(roles, cfg.defaultRole) {
+			logger.Error(fmt.Sprintf("default role %q doesn't exist", cfg.defaultRole))
+			os.Exit(1)
+		}
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer,
+		config:       cfg,
+		logger:       logger,
+		models:       models,
+		mailer:       mailer,
+		jwt:          jwtKeys,
+		loginLimiter: newLoginLimiter(cfg.login.maxAttempts, cfg.login.lockout, cfg.login.maxLockout),
+	}
+
+	if cfg.auth.purgeInterval > 0 {
+		go app.purgeExpiredTokens()
+	}
+
+	if cfg.deletion.purgeInterval > 0 {
+		go app.purgeDeletedUsers()
+	}
+
+	if cfg.trash.purgeInterval > 0 {
+		go app.purgeDeletedMovies()
 	}
 
 	err = app.serve()
@@ -152,6 +317,28 @@ func main() {
 	}
 }
 
+// Parses a single kid:alg:base64 value of the -jwt-keys flag
+func parseJWTKey(val string) (*jwt.Key, error) {
+	parts := strings.SplitN(val, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid jwt key %q, expected kid:alg:base64", val)
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 for jwt key %q", parts[0])
+	}
+
+	switch parts[1] {
+	case jwt.AlgHS256:
+		return jwt.NewHS256Key(parts[0], secret)
+	case jwt.AlgEdDSA:
+		return jwt.NewEdDSAKey(parts[0], secret)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q for jwt key %q", parts[1], parts[0])
+	}
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"errors"
 	"expvar"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/grglucastr/go-greenlight/internal/data"
 	"github.com/grglucastr/go-greenlight/internal/validator"
@@ -159,6 +161,42 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 }
 
 func (app *application) authenticate(next http.Handler) http.Handler {
+
+	// Tokens that were used since the last flush. Writing last_used_at on every
+	// request would double the database work of each authenticated call, so we
+	// collect the tokens here and update them all at once.
+	var (
+		mu       sync.Mutex
+		lastUsed = make(map[string]struct{})
+	)
+
+	// background goroutine which flushes the used tokens to the database once
+	// every minute.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			mu.Lock()
+
+			tokens := make([]string, 0, len(lastUsed))
+			for token := range lastUsed {
+				tokens = append(tokens, token)
+			}
+			clear(lastUsed)
+
+			mu.Unlock()
+
+			if len(tokens) == 0 {
+				continue
+			}
+
+			err := app.models.Tokens.UpdateLastUsed(tokens, time.Now())
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+	}()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		// Indicates to any caches that the response may vary
@@ -173,13 +211,52 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		if len(headerParts) != 2 {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// Machine clients authenticate with "Authorization: ApiKey <key>"
+		if headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(next, w, r, headerParts[1])
+			return
+		}
+
+		if headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
 		token := headerParts[1]
 
+		// JWTs are verified locally. The user isn't loaded from the database,
+		// so only the fields carried by the claims are filled in. It also
+		// means a JWT can't be revoked: logging out, resetting the password
+		// or deleting the account only stop its session from being
+		// refreshed, and the JWT stays valid until it expires.
+		if app.config.auth.mode == authModeJWT {
+			claims, err := app.jwt.Verify(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			user := &data.User{ID: userID, Activated: claims.Activated}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetToken(r, token)
+			r = app.contextSetPermissions(r, data.Permissions(claims.Permissions))
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -198,12 +275,48 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		mu.Lock()
+		lastUsed[token] = struct{}{}
+		mu.Unlock()
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+func (app *application) authenticateAPIKey(next http.Handler, w http.ResponseWriter, r *http.Request, keyPlaintext string) {
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, keyPlaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	key, err := app.models.APIKeys.GetForKey(keyPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -217,6 +330,22 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
+// Checks that a user is authenticated with a login session. Managing the
+// account is out of reach of API keys, even unrestricted ones, so that a
+// leaked or narrowed key can't be used to take over the account.
+func (app *application) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 // Checks that a user is both authenticated and activated
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 
@@ -237,19 +366,27 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Use the permissions from the credentials if there are any, otherwise
+		// load them from the database and keep them for the handler.
+		permissions, err := app.currentPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
+		r = app.contextSetPermissions(r, permissions)
+
 		if !permissions.Include(code) {
 			app.notPermittedResponse(w, r)
 			return
 		}
 
+		// API keys can be narrowed down to a subset of the user's permissions
+		if key := app.contextGetAPIKey(r); key != nil && !key.Allows(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	}
 
@@ -291,6 +428,23 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// Gives every request an ID, sent back in the X-Request-Id header, so a log
+// line or an audit event can be traced back to the request that caused it.
+// An ID set by a proxy in front of us is kept when it looks sane.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+
+		if id == "" || len(id) > 128 || strings.ContainsFunc(id, unicode.IsControl) {
+			id = rand.Text()
+		}
+
+		w.Header().Set("X-Request-Id", id)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
 func (app *application) metrics(next http.Handler) http.Handler {
 	var (
 		totalRequestReceived            = expvar.NewInt("total_request_received")
@@ -7,7 +7,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) routes() http.Handler {
+func (app *application) newRouter() *httprouter.Router {
 	router := httprouter.New()
 
 	// Customizing the default not found route from httprouter
@@ -16,6 +16,12 @@ func (app *application) routes() http.Handler {
 	// customizing the default method not allowed from httprouter
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	return router
+}
+
+func (app *application) routes() http.Handler {
+	router := app.newRouter()
+
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
 	// Use the requireActivatedUser() middleware on our five /v1/movies** endpoints
@@ -24,13 +30,110 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.updateMovieCreditsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("reviews:write", app.createReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/reviews/:id", app.requirePermission("movies:read", app.showReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requirePermission("reviews:write", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requirePermission("reviews:write", app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email/revert", app.revertEmailChangeHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", app.requirePermission("users:admin", app.updateUserHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/invitations", app.requirePermission("users:admin", app.listInvitationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/invitations", app.requirePermission("users:admin", app.createInvitationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/invitations/:id", app.requirePermission("users:admin", app.deleteInvitationHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/permissions", app.requirePermission("permissions:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/permissions", app.requirePermission("permissions:admin", app.listUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/permissions", app.requirePermission("permissions:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/permissions", app.requirePermission("permissions:admin", app.revokeUserPermissionsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requirePermission("permissions:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/roles", app.requirePermission("permissions:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/roles/:id", app.requirePermission("permissions:admin", app.showRoleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/roles/:id", app.requirePermission("permissions:admin", app.updateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/roles/:id", app.requirePermission("permissions:admin", app.deleteRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/roles", app.requirePermission("permissions:admin", app.listUserRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/roles", app.requirePermission("permissions:admin", app.grantUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/roles", app.requirePermission("permissions:admin", app.revokeUserRolesHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireSession(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireSession(app.deleteAllAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/audit", app.requirePermission("audit:admin", app.listAuditEventsHandler))
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// httprouter can't match a fixed path segment and a named parameter in the
+	// same position, so the /v1/users/me/** endpoints of the current user can't
+	// share a router with the /v1/users/:id/** ones. They get a router of
+	// their own instead, picked by a ServeMux in front of both.
+	me := app.newRouter()
+
+	// Managing the account needs a login session, API keys are only good for
+	// the watchlist routes, which go through requirePermission like the rest.
+	me.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireSession(app.showCurrentUserHandler))
+	me.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireSession(app.updateCurrentUserHandler))
+	me.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireSession(app.deleteCurrentUserHandler))
+	me.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireSession(app.exportCurrentUserHandler))
+	me.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireSession(app.updateCurrentUserPasswordHandler))
+	me.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireActivatedUser(app.requireSession(app.createEmailChangeHandler)))
+
+	me.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireSession(app.listSessionsHandler))
+	me.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireSession(app.deleteSessionHandler))
+
+	me.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireActivatedUser(app.requireSession(app.listAPIKeysHandler)))
+	me.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireActivatedUser(app.requireSession(app.createAPIKeyHandler)))
+	me.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireActivatedUser(app.requireSession(app.deleteAPIKeyHandler)))
+
+	me.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireActivatedUser(app.requireSession(app.createTOTPHandler)))
+	me.HandlerFunc(http.MethodPut, "/v1/users/me/totp/enabled", app.requireActivatedUser(app.requireSession(app.enableTOTPHandler)))
+	me.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireActivatedUser(app.requireSession(app.deleteTOTPHandler)))
+
+	me.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listWatchlistHandler))
+	me.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.addToWatchlistHandler))
+	me.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.removeFromWatchlistHandler))
+
+	me.HandlerFunc(http.MethodGet, "/v1/users/me/watched", app.requirePermission("movies:read", app.listWatchedHandler))
+	me.HandlerFunc(http.MethodPost, "/v1/users/me/watched", app.requirePermission("movies:read", app.createWatchedHandler))
+	me.HandlerFunc(http.MethodDelete, "/v1/users/me/watched/:id", app.requirePermission("movies:read", app.deleteWatchedHandler))
+
+	// Same for /v1/movies/trash next to /v1/movies/:id
+	trash := app.newRouter()
+
+	trash.HandlerFunc(http.MethodGet, "/v1/movies/trash", app.requirePermission("movies:write", app.listDeletedMoviesHandler))
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/users/me", me)
+	mux.Handle("/v1/users/me/", me)
+	mux.Handle("/v1/movies/trash", trash)
+	mux.Handle("/", router)
+
+	return app.metrics(app.requestID(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(mux))))))
 }
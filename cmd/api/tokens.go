@@ -3,16 +3,22 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grglucastr/go-greenlight/internal/data"
+	"github.com/grglucastr/go-greenlight/internal/jwt"
 	"github.com/grglucastr/go-greenlight/internal/validator"
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -31,10 +37,23 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Failed attempts are tracked both per account and per client, so
+	// guessing the password of one account from many IPs is slowed down just
+	// like trying many accounts from one IP.
+	emailKey := "email:" + strings.ToLower(input.Email)
+	ipKey := "ip:" + realip.FromRequest(r)
+
+	if wait := app.loginLimiter.wait(emailKey, ipKey); wait > 0 {
+		app.audit(r, data.AuditLoginFailed, "", 0, nil, envelope{"email": input.Email, "reason": "locked"})
+		app.loginLockedResponse(w, r, wait)
+		return
+	}
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedLogin(r, nil, input.Email, "unknown email", emailKey, ipKey)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -49,18 +68,459 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		app.failedLogin(r, user, input.Email, "wrong password", emailKey, ipKey)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// Users with two-factor authentication enabled need a second factor
+	// on top of their password.
+	totpSettings, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if totpSettings != nil && totpSettings.Enabled {
+		if input.TOTPCode == "" && input.RecoveryCode == "" {
+			app.twoFactorRequiredResponse(w, r)
+			return
+		}
+
+		ok, err := app.verifySecondFactor(totpSettings, input.TOTPCode, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.failedLogin(r, user, input.Email, "wrong second factor", emailKey, ipKey)
+			app.invalidCredentialsResponse(w, r)
+			return
+		}
+	}
+
+	// The IP key is left alone, otherwise an attacker could clear it by
+	// logging in to an account of their own now and then.
+	app.loginLimiter.reset(emailKey)
+
+	// This is the only moment we know the plaintext password, so use it to
+	// upgrade outdated hashes. A failure here shouldn't stop the login.
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.models.Users.Update(user)
+		}
+
+		if err != nil {
+			app.logger.Error("unable to rehash password", "error", err.Error(), "user_id", user.ID)
+		}
+	}
+
+	// Logging in during the grace period of a deleted account brings it back
+	cancelled, err := app.models.Users.CancelDeletion(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	accessToken, refreshToken, err := app.newTokenPair(user, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditLoginSucceeded, data.AuditTargetUser, user.ID, nil, nil)
+
+	env := envelope{"autentication_token": accessToken, "refresh_token": refreshToken}
+
+	if cancelled {
+		env["message"] = "the scheduled deletion of your account was cancelled"
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Records a failed login. When it locks the account, the owner is told by
+// email, since it usually means somebody is guessing their password.
+func (app *application) failedLogin(r *http.Request, user *data.User, email, reason, emailKey, ipKey string) {
+	var userID int64
+	if user != nil {
+		userID = user.ID
+	}
+
+	app.audit(r, data.AuditLoginFailed, data.AuditTargetUser, userID, nil, envelope{"email": email, "reason": reason})
+
+	app.loginLimiter.fail(ipKey)
+
+	lockout := app.loginLimiter.fail(emailKey)
+	if lockout == 0 || user == nil {
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"lockout": lockout.String(),
+		}
+
+		err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
+
+// Exchanges a refresh token for a new access and refresh token pair. The old
+// refresh token can't be used again.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The user is looked up before the token is rotated, so nothing can
+	// fail between rotating it and sending the new tokens back. Rotated
+	// tokens are still found here, Rotate() is the one detecting the reuse.
+	user, err := app.models.Users.GetForToken(data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	accessToken, refreshToken, err := app.rotateTokenPair(user, input.RefreshToken, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.logger.Warn("refresh token reuse detected, token family revoked", "ip", realip.FromRequest(r))
+			app.audit(r, data.AuditRefreshTokenReused, "", 0, nil, nil)
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"autentication_token": accessToken, "refresh_token": refreshToken}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revokes the bearer token used to authenticate the current request, along
+// with the refresh tokens issued for the same login
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.contextGetToken(r)
+
+	var err error
+
+	// A JWT can't be deleted, but revoking its family stops it from being
+	// refreshed once it expires.
+	if app.config.auth.mode == authModeJWT {
+		claims, verifyErr := app.jwt.Verify(token)
+		if verifyErr != nil {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		err = app.models.Tokens.DeleteFamilyForUser(claims.SessionID, app.contextGetUser(r).ID)
+	} else {
+		err = app.models.Tokens.DeleteFamily(data.ScopeAuthentication, token)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditTokenRevoked, data.AuditTargetUser, app.contextGetUser(r).ID, nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revokes every authentication token of the current user, logging out all of
+// their sessions, including the one making this request.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditAllTokensRevoked, data.AuditTargetUser, user.ID, nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only activated accounts are allowed to reset their password
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The password reset token is short-lived, 45 minutes should be enough
+	// for the user to check their inbox and choose a new password.
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"autentication_token": token}, nil)
+	// We always send back the same response, whether the email belongs to an
+	// account or not, so that this endpoint can't be used to discover which
+	// email addresses are registered.
+	env := envelope{"message": "if the email address belongs to an account pending activation, an email will be sent to you containing activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Issues the access and refresh tokens of a login. The access token is either
+// an opaque token or a signed JWT, depending on the configured authentication
+// mode.
+func (app *application) newTokenPair(user *data.User, r *http.Request) (*data.Token, *data.Token, error) {
+	if app.config.auth.mode != authModeJWT {
+		return app.models.Tokens.NewPair(
+			user.ID,
+			app.config.auth.accessTokenTTL,
+			app.config.auth.refreshTokenTTL,
+			r.UserAgent(),
+			realip.FromRequest(r),
+		)
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := app.models.Tokens.NewRefresh(user.ID, app.config.auth.refreshTokenTTL, r.UserAgent(), realip.FromRequest(r))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accessToken, err := app.signAccessToken(user, permissions, refreshToken.Family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// Like newTokenPair(), but exchanges a refresh token for the next tokens of
+// its family.
+func (app *application) rotateTokenPair(user *data.User, refreshTokenPlaintext string, r *http.Request) (*data.Token, *data.Token, error) {
+	if app.config.auth.mode != authModeJWT {
+		return app.models.Tokens.Rotate(
+			refreshTokenPlaintext,
+			app.config.auth.accessTokenTTL,
+			app.config.auth.refreshTokenTTL,
+			r.UserAgent(),
+			realip.FromRequest(r),
+		)
+	}
+
+	// Everything that can fail is done before the refresh token is rotated
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, refreshToken, err := app.models.Tokens.Rotate(refreshTokenPlaintext, 0, app.config.auth.refreshTokenTTL, r.UserAgent(), realip.FromRequest(r))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accessToken, err := app.signAccessToken(user, permissions, refreshToken.Family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func (app *application) signAccessToken(user *data.User, permissions data.Permissions, family string) (*data.Token, error) {
+	now := time.Now()
+	expiry := now.Add(app.config.auth.accessTokenTTL)
+
+	claims := jwt.Claims{
+		Subject:     strconv.FormatInt(user.ID, 10),
+		SessionID:   family,
+		IssuedAt:    now.Unix(),
+		NotBefore:   now.Unix(),
+		ExpiresAt:   expiry.Unix(),
+		Activated:   user.Activated,
+		Permissions: permissions,
+	}
+
+	signed, err := app.jwt.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken := &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    time.Unix(claims.ExpiresAt, 0),
+		Scope:     data.ScopeAuthentication,
+	}
+
+	return accessToken, nil
+}
+
+// Background loop removing the expired tokens, rotated refresh tokens
+// included
+func (app *application) purgeExpiredTokens() {
+	for {
+		time.Sleep(app.config.auth.purgeInterval)
+
+		deleted, err := app.models.Tokens.DeleteExpired()
+		if err != nil {
+			app.logger.Error("unable to purge expired tokens", "error", err.Error())
+			continue
+		}
+
+		if deleted > 0 {
+			app.logger.Info("purged expired tokens", "count", deleted)
+		}
+	}
+}
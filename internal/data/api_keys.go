@@ -0,0 +1,202 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grglucastr/go-greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// APIKey is a long-lived credential for machine clients. When Permissions is
+// nil the key acts with every permission of its owner, otherwise it's
+// narrowed down to the listed codes.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitzero"`
+	Hash        []byte      `json:"-"`
+	Expiry      *time.Time  `json:"expiry,omitzero"`
+	Permissions Permissions `json:"permissions,omitzero"`
+}
+
+// Reports whether the key allows the given permission code. The owner
+// must still hold the permission, this only narrows it down.
+func (k *APIKey) Allows(code string) bool {
+	if k.Permissions == nil {
+		return true
+	}
+
+	return k.Permissions.Include(code)
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+
+	if key.Permissions != nil {
+		v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+		v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	}
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// The plaintext key is only available on the returned value, we store
+// nothing but its SHA-256 hash.
+func (m APIKeyModel) New(key *APIKey) error {
+	key.Plaintext = rand.Text()
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return m.Insert(key)
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, hash, expiry, permissions)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	var permissions any
+	if key.Permissions != nil {
+		permissions = pq.Array([]string(key.Permissions))
+	}
+
+	args := []any{key.UserID, key.Name, key.Hash, key.Expiry, permissions}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// Returns the unexpired key matching the plaintext
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*APIKey, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		SELECT id, created_at, user_id, name, expiry, permissions
+		FROM api_keys
+		WHERE hash = $1
+		AND (expiry IS NULL OR expiry > $2)`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], time.Now()).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Expiry,
+		pq.Array((*[]string)(&key.Permissions)),
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, user_id, name, expiry, permissions
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.UserID,
+			&key.Name,
+			&key.Expiry,
+			pq.Array((*[]string)(&key.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m APIKeyModel) DeleteForUser(id, userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
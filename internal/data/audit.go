@@ -0,0 +1,242 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditUserRegistered     = "user.registered"
+	AuditUserActivated      = "user.activated"
+	AuditUserUpdated        = "user.updated"
+	AuditLoginSucceeded     = "login.succeeded"
+	AuditLoginFailed        = "login.failed"
+	AuditTokenRevoked       = "token.revoked"
+	AuditAllTokensRevoked   = "token.revoked_all"
+	AuditRefreshTokenReused = "token.reused"
+	AuditSessionRevoked     = "session.revoked"
+	AuditPermissionsGranted = "permissions.granted"
+	AuditPermissionsRevoked = "permissions.revoked"
+	AuditRoleCreated        = "role.created"
+	AuditRoleUpdated        = "role.updated"
+	AuditRoleDeleted        = "role.deleted"
+	AuditRolesGranted       = "roles.granted"
+	AuditRolesRevoked       = "roles.revoked"
+	AuditMovieCreated       = "movie.created"
+	AuditMovieUpdated       = "movie.updated"
+	AuditMovieDeleted       = "movie.deleted"
+	AuditMovieRestored      = "movie.restored"
+	AuditMovieReverted      = "movie.reverted"
+	AuditCreditsUpdated     = "movie.credits_updated"
+	AuditPersonCreated      = "person.created"
+	AuditPersonUpdated      = "person.updated"
+	AuditPersonDeleted      = "person.deleted"
+	AuditReviewCreated      = "review.created"
+	AuditReviewUpdated      = "review.updated"
+	AuditReviewDeleted      = "review.deleted"
+)
+
+// Types of records an event can be about
+const (
+	AuditTargetUser   = "user"
+	AuditTargetRole   = "role"
+	AuditTargetMovie  = "movie"
+	AuditTargetPerson = "person"
+	AuditTargetReview = "review"
+)
+
+// AuditEvent records who did what to which record. Before and After only
+// hold the fields that changed, or the whole record when it was created or
+// deleted.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *int64          `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type,omitzero"`
+	TargetID   *int64          `json:"target_id,omitzero"`
+	IP         string          `json:"ip,omitzero"`
+	RequestID  string          `json:"request_id,omitzero"`
+	Before     json.RawMessage `json:"before,omitzero"`
+	After      json.RawMessage `json:"after,omitzero"`
+}
+
+// Sets Before and After to the difference between the two values. Either of
+// them can be nil, in which case the other one is kept whole.
+func (e *AuditEvent) SetDiff(before, after any) error {
+	b, err := marshalAuditValue(before)
+	if err != nil {
+		return err
+	}
+
+	a, err := marshalAuditValue(after)
+	if err != nil {
+		return err
+	}
+
+	e.Before, e.After = b, a
+
+	if b == nil || a == nil {
+		return nil
+	}
+
+	var beforeFields, afterFields map[string]json.RawMessage
+
+	// Only JSON objects can be diffed field by field, anything else is
+	// kept whole.
+	if json.Unmarshal(b, &beforeFields) != nil || json.Unmarshal(a, &afterFields) != nil {
+		return nil
+	}
+
+	for key, value := range beforeFields {
+		if other, ok := afterFields[key]; ok && bytes.Equal(value, other) {
+			delete(beforeFields, key)
+			delete(afterFields, key)
+		}
+	}
+
+	e.Before, err = json.Marshal(beforeFields)
+	if err != nil {
+		return err
+	}
+
+	e.After, err = json.Marshal(afterFields)
+	return err
+}
+
+func marshalAuditValue(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(v)
+}
+
+// AuditFilters narrows down the events returned by GetAll(). Zero values
+// aren't filtered on.
+type AuditFilters struct {
+	ActorID       *int64
+	Action        string
+	TargetType    string
+	TargetID      *int64
+	RequestID     string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m AuditModel) Insert(event *AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, ip, request_id, before, after)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8)
+		RETURNING id, created_at`
+
+	args := []any{
+		event.ActorID,
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		event.IP,
+		event.RequestID,
+		nullableJSON(event.Before),
+		nullableJSON(event.After),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (m AuditModel) GetAll(auditFilters AuditFilters, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, actor_id, action, COALESCE(target_type, ''), target_id,
+			COALESCE(ip, ''), COALESCE(request_id, ''), before, after
+		FROM audit_events
+		WHERE (actor_id = $1 OR $1 IS NULL)
+		AND (action = $2 OR $2 = '')
+		AND (target_type = $3 OR $3 = '')
+		AND (target_id = $4 OR $4 IS NULL)
+		AND (request_id = $5 OR $5 = '')
+		AND (created_at >= $6 OR $6 IS NULL)
+		AND (created_at < $7 OR $7 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $8 OFFSET $9`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		auditFilters.ActorID,
+		auditFilters.Action,
+		auditFilters.TargetType,
+		auditFilters.TargetID,
+		auditFilters.RequestID,
+		auditFilters.CreatedAfter,
+		auditFilters.CreatedBefore,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var before, after []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&event.IP,
+			&event.RequestID,
+			&before,
+			&after,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		event.Before, event.After = before, after
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// A nil json.RawMessage must reach the database as NULL, not as an empty
+// string which isn't valid JSON.
+func nullableJSON(raw json.RawMessage) any {
+	if raw == nil {
+		return nil
+	}
+
+	return []byte(raw)
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grglucastr/go-greenlight/internal/validator"
+)
+
+const (
+	CreditRoleDirector = "director"
+	CreditRoleActor    = "actor"
+	CreditRoleWriter   = "writer"
+)
+
+var (
+	ErrUnknownPerson = errors.New("unknown person")
+)
+
+// Credit links a person to a movie, with a role on it
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	MovieTitle   string `json:"movie_title,omitzero"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"person_name,omitzero"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitzero"`
+	BillingOrder int32  `json:"billing_order,omitzero"`
+}
+
+func ValidateCredit(v *validator.Validator, i int, credit *Credit) {
+	key := fmt.Sprintf("credits[%d]", i)
+
+	v.Check(credit.PersonID > 0, key, "person_id must be provided")
+	v.Check(validator.PermittedValue(credit.Role, CreditRoleDirector, CreditRoleActor, CreditRoleWriter), key, "role must be director, actor or writer")
+	v.Check(credit.Character == "" || credit.Role == CreditRoleActor, key, "only actors can have a character")
+	v.Check(len(credit.Character) <= 500, key, "character must not be more than 500 bytes long")
+	v.Check(credit.BillingOrder >= 0, key, "billing_order must not be negative")
+}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// Credits of a movie, directors first, then writers and actors, each in
+// billing order.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT c.id, c.movie_id, mo.title, c.person_id, p.name, c.role, COALESCE(c.character, ''), COALESCE(c.billing_order, 0)
+		FROM movie_credits c
+		INNER JOIN movies mo ON mo.id = c.movie_id
+		INNER JOIN people p ON p.id = c.person_id
+		WHERE c.movie_id = $1
+		ORDER BY array_position(ARRAY['director', 'writer', 'actor'], c.role), c.billing_order ASC NULLS LAST, c.id ASC`
+
+	return m.queryCredits(query, movieID)
+}
+
+// Credits of a person, newest movies first
+func (m CreditModel) GetAllForPerson(personID int64) ([]*Credit, error) {
+	query := `
+		SELECT c.id, c.movie_id, mo.title, c.person_id, p.name, c.role, COALESCE(c.character, ''), COALESCE(c.billing_order, 0)
+		FROM movie_credits c
+		INNER JOIN movies mo ON mo.id = c.movie_id
+		INNER JOIN people p ON p.id = c.person_id
+		WHERE c.person_id = $1 AND mo.deleted_at IS NULL
+		ORDER BY mo.year DESC, c.id ASC`
+
+	return m.queryCredits(query, personID)
+}
+
+func (m CreditModel) queryCredits(query string, args ...any) ([]*Credit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.MovieTitle,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// Replaces every credit of a movie in a single transaction
+func (m CreditModel) SetForMovie(movieID int64, credits []*Credit) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, 0))
+		RETURNING id`
+
+	for _, credit := range credits {
+		args := []any{movieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: insert or update on table "movie_credits" violates foreign key constraint "movie_credits_person_id_fkey"`:
+				return ErrUnknownPerson
+			default:
+				return err
+			}
+		}
+
+		credit.MovieID = movieID
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,250 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grglucastr/go-greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Invitation lets somebody register while registration is invite-only. Its
+// token is sent to the invited address, so registering with it proves the
+// email is real and the account starts activated, with the preassigned
+// permissions instead of the default role.
+//
+// Invitation tokens aren't tied to an existing user yet, which is why they
+// live in a table of their own instead of tokens.
+type Invitation struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	CreatedBy   int64       `json:"created_by,omitzero"`
+	Email       string      `json:"email"`
+	Plaintext   string      `json:"-"`
+	Hash        []byte      `json:"-"`
+	Expiry      time.Time   `json:"expiry"`
+	Permissions Permissions `json:"permissions"`
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values")
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// Generates the invitation token the same way as every other token, under
+// the invitation scope, and stores the invitation.
+func (m InvitationModel) New(invitation *Invitation, ttl time.Duration) error {
+	token := generateToken(invitation.CreatedBy, ttl, ScopeInvitation)
+
+	invitation.Plaintext = token.Plaintext
+	invitation.Hash = token.Hash
+	invitation.Expiry = token.Expiry
+
+	if invitation.Permissions == nil {
+		invitation.Permissions = Permissions{}
+	}
+
+	return m.Insert(invitation)
+}
+
+func (m InvitationModel) Insert(invitation *Invitation) error {
+	query := `
+		INSERT INTO invitations (created_by, email, hash, expiry, permissions)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{
+		invitation.CreatedBy,
+		invitation.Email,
+		invitation.Hash,
+		invitation.Expiry,
+		pq.Array([]string(invitation.Permissions)),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+}
+
+// Returns the unexpired invitation matching the plaintext token
+func (m InvitationModel) GetForToken(tokenPlaintext string) (*Invitation, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT id, created_at, COALESCE(created_by, 0), email, expiry, permissions
+		FROM invitations
+		WHERE hash = $1
+		AND expiry > $2`
+
+	var invitation Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+		&invitation.CreatedBy,
+		&invitation.Email,
+		&invitation.Expiry,
+		pq.Array((*[]string)(&invitation.Permissions)),
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// Returns the invitations which haven't expired nor been used yet
+func (m InvitationModel) GetAllPending() ([]*Invitation, error) {
+	query := `
+		SELECT id, created_at, COALESCE(created_by, 0), email, expiry, permissions
+		FROM invitations
+		WHERE expiry > $1
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.CreatedAt,
+			&invitation.CreatedBy,
+			&invitation.Email,
+			&invitation.Expiry,
+			pq.Array((*[]string)(&invitation.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+func (m InvitationModel) Delete(id int64) error {
+	query := `
+		DELETE FROM invitations
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Registers the invited user. Inserting the user, granting the invitation's
+// permissions and deleting every invitation sent to the address happen in
+// one transaction, so a failure can't leave a user without their
+// permissions or an invitation that can be used twice. Returns
+// ErrRecordNotFound when the invitation was used in the meantime.
+func (m InvitationModel) Accept(invitation *Invitation, user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// Deleting first locks the invitation rows, so a concurrent registration
+	// with the same token waits here and then finds it gone
+	query := `
+		DELETE FROM invitations
+		WHERE lower(email) = lower($1)
+		RETURNING id`
+
+	rows, err := tx.QueryContext(ctx, query, invitation.Email)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	found := false
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		found = found || id == invitation.ID
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrRecordNotFound
+	}
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, user.ID, pq.Array([]string(invitation.Permissions)))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
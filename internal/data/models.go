@@ -11,17 +11,43 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	APIKeys        APIKeyModel
+	Audit          AuditModel
+	Credits        CreditModel
+	Invitations    InvitationModel
+	Movies         MovieModel
+	MovieRevisions MovieRevisionModel
+	People         PersonModel
+	Permissions    PermissionModel
+	Reviews        ReviewModel
+	Roles          RoleModel
+	TOTP           TOTPModel
+	Tokens         TokenModel
+	Users          UserModel
+	Watched        WatchedModel
+	Watchlist      WatchlistModel
 }
 
 func NewModels(db *sql.DB) Models {
+	// The permissions cache is shared by the two models that can change
+	// the permissions of a user.
+	cache := newPermissionsCache(permissionsCacheTTL)
+
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db},
+		Audit:          AuditModel{DB: db},
+		Credits:        CreditModel{DB: db},
+		Invitations:    InvitationModel{DB: db},
+		Movies:         MovieModel{DB: db},
+		MovieRevisions: MovieRevisionModel{DB: db},
+		People:         PersonModel{DB: db},
+		Permissions:    PermissionModel{DB: db, cache: cache},
+		Reviews:        ReviewModel{DB: db},
+		Roles:          RoleModel{DB: db, cache: cache},
+		TOTP:           TOTPModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		Users:          UserModel{DB: db},
+		Watched:        WatchedModel{DB: db},
+		Watchlist:      WatchlistModel{DB: db},
 	}
 }
@@ -0,0 +1,197 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieRevision is the state of a movie at a given version. UserID is nil
+// when the editor is unknown or their account is gone.
+type MovieRevision struct {
+	ID        int64     `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Runtime   Runtime   `json:"runtime"`
+	Genres    []string  `json:"genres"`
+	UserID    *int64    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FieldChange is the value of a field before and after a revision
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Returns the fields changed since the previous revision. Every field is
+// listed for the first revision, whose previous one is nil.
+func (r *MovieRevision) Diff(previous *MovieRevision) map[string]FieldChange {
+	diff := map[string]FieldChange{}
+
+	if previous == nil {
+		diff["title"] = FieldChange{From: nil, To: r.Title}
+		diff["year"] = FieldChange{From: nil, To: r.Year}
+		diff["runtime"] = FieldChange{From: nil, To: r.Runtime}
+		diff["genres"] = FieldChange{From: nil, To: r.Genres}
+
+		return diff
+	}
+
+	if r.Title != previous.Title {
+		diff["title"] = FieldChange{From: previous.Title, To: r.Title}
+	}
+
+	if r.Year != previous.Year {
+		diff["year"] = FieldChange{From: previous.Year, To: r.Year}
+	}
+
+	if r.Runtime != previous.Runtime {
+		diff["runtime"] = FieldChange{From: previous.Runtime, To: r.Runtime}
+	}
+
+	if !slices.Equal(r.Genres, previous.Genres) {
+		diff["genres"] = FieldChange{From: previous.Genres, To: r.Genres}
+	}
+
+	return diff
+}
+
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+		SELECT id, movie_id, version, title, year, runtime, genres, user_id, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.ID,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&revision.UserID,
+		&revision.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// Returns the revision stored right before the given version, which isn't
+// always version - 1 for movies created before revisions were recorded.
+func (m MovieRevisionModel) GetPrevious(movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+		SELECT version
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version < $2
+		ORDER BY version DESC
+		LIMIT 1`
+
+	var previous int32
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(&previous)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return m.Get(movieID, previous)
+}
+
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, movie_id, version, title, year, runtime, genres, user_id, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.ID,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&revision.UserID,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// Stores the current state of the movie as a revision. It's called by
+// MovieModel in the transaction changing the movie.
+func insertMovieRevision(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7::bigint, 0))`
+
+	args := []any{movie.ID, movie.Version, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), userID}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
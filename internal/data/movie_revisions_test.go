@@ -0,0 +1,86 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMovieRevisionDiff(t *testing.T) {
+	base := MovieRevision{
+		Version: 1,
+		Title:   "Moana",
+		Year:    2016,
+		Runtime: 107,
+		Genres:  []string{"animation", "adventure"},
+	}
+
+	edit := func(change func(r *MovieRevision)) *MovieRevision {
+		r := base
+		r.Version = 2
+		r.Genres = append([]string(nil), base.Genres...)
+		change(&r)
+		return &r
+	}
+
+	tests := []struct {
+		name     string
+		revision *MovieRevision
+		previous *MovieRevision
+		want     map[string]FieldChange
+	}{
+		{
+			name:     "first revision",
+			revision: &base,
+			previous: nil,
+			want: map[string]FieldChange{
+				"title":   {From: nil, To: "Moana"},
+				"year":    {From: nil, To: int32(2016)},
+				"runtime": {From: nil, To: Runtime(107)},
+				"genres":  {From: nil, To: []string{"animation", "adventure"}},
+			},
+		},
+		{
+			name:     "unchanged",
+			revision: edit(func(r *MovieRevision) {}),
+			previous: &base,
+			want:     map[string]FieldChange{},
+		},
+		{
+			name:     "genres only",
+			revision: edit(func(r *MovieRevision) { r.Genres = []string{"animation"} }),
+			previous: &base,
+			want: map[string]FieldChange{
+				"genres": {From: []string{"animation", "adventure"}, To: []string{"animation"}},
+			},
+		},
+		{
+			name:     "genres reordered",
+			revision: edit(func(r *MovieRevision) { r.Genres = []string{"adventure", "animation"} }),
+			previous: &base,
+			want: map[string]FieldChange{
+				"genres": {From: []string{"animation", "adventure"}, To: []string{"adventure", "animation"}},
+			},
+		},
+		{
+			name: "title, year and runtime",
+			revision: edit(func(r *MovieRevision) {
+				r.Title = "Moana 2"
+				r.Year = 2024
+				r.Runtime = 100
+			}),
+			previous: &base,
+			want: map[string]FieldChange{
+				"title":   {From: "Moana", To: "Moana 2"},
+				"year":    {From: int32(2016), To: int32(2024)},
+				"runtime": {From: Runtime(107), To: Runtime(100)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := tt.revision.Diff(tt.previous)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
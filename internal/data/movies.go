@@ -17,13 +17,23 @@ const THREE_SECONDS = 3 * time.Second
 // be visible because of 'enconding/json'
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitzero"`
-	Runtime   Runtime   `json:"runtime,omitzero"`
-	Genres    []string  `json:"genres,omitzero"`
-	Version   int32     `json:"version"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"-"`
+	Title     string     `json:"title"`
+	Year      int32      `json:"year,omitzero"`
+	Runtime   Runtime    `json:"runtime,omitzero"`
+	Genres    []string   `json:"genres,omitzero"`
+	Version   int32      `json:"version"`
+	Rating    Rating     `json:"rating"`
+	Credits   []*Credit  `json:"credits,omitzero"`
+	DeletedAt *time.Time `json:"deleted_at,omitzero"`
+}
+
+// Rating aggregates the review scores of a movie. It's kept up to date by
+// ReviewModel and never changed through the movie itself.
+type Rating struct {
+	Average float64 `json:"average"`
+	Count   int32   `json:"count"`
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -48,7 +58,9 @@ type MovieModel struct {
 	DB *sql.DB
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// Inserts the movie and its first revision, made by the given user, in the
+// same transaction.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
 
 	query := `
 		INSERT INTO movies (title, year, runtime, genres)
@@ -61,15 +73,32 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = insertMovieRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, created_at, title, year, runtime, genres, version, rating_average, rating_count
 		FROM movies
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL`
 
 	var mo Movie
 
@@ -88,6 +117,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&mo.Runtime,
 		pq.Array(&mo.Genres),
 		&mo.Version,
+		&mo.Rating.Average,
+		&mo.Rating.Count,
 	)
 
 	if err != nil {
@@ -102,12 +133,14 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &mo, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// Updates the movie and stores the new version as a revision made by the
+// given user, in the same transaction.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
 
 	query := `
 		UPDATE movies 
 		SET title = $1, year= $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 		RETURNING version`
 
 	args := []any{
@@ -122,7 +155,14 @@ func (m MovieModel) Update(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), THREE_SECONDS)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -132,15 +172,57 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
+	err = insertMovieRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
+// Moves the movie to the trash. It's hidden from Get() and GetAll() until
+// it's restored, or removed for good by PurgeDeleted().
 func (m MovieModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `DELETE FROM movies WHERE ID = $1`
+	query := `
+		UPDATE movies
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), THREE_SECONDS)
+
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Takes the movie out of the trash
+func (m MovieModel) Restore(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), THREE_SECONDS)
 
@@ -163,21 +245,63 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// Removes for good the movies which went to the trash before the given time
+func (m MovieModel) PurgeDeleted(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at <= $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// MovieFilters narrows down the movies returned by GetAll(). Zero values
+// aren't filtered on. OnWatchlist and Watched are relative to UserID.
+type MovieFilters struct {
+	Title       string
+	Genres      []string
+	PersonID    int64
+	UserID      int64
+	OnWatchlist *bool
+	Watched     *bool
+}
+
+func (m MovieModel) GetAll(movieFilters MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
 
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, rating_average, rating_count
 		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		WHERE deleted_at IS NULL
+		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (genres @> $2 OR $2 = '{}')
+		AND (id IN (SELECT movie_id FROM movie_credits WHERE person_id = $3) OR $3 = 0)
+		AND ($5::boolean IS NULL OR $5 = EXISTS (SELECT 1 FROM watchlist WHERE user_id = $4 AND movie_id = movies.id))
+		AND ($6::boolean IS NULL OR $6 = EXISTS (SELECT 1 FROM watched WHERE user_id = $4 AND movie_id = movies.id))
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $7 OFFSET $8`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), THREE_SECONDS)
 
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []any{
+		movieFilters.Title,
+		pq.Array(movieFilters.Genres),
+		movieFilters.PersonID,
+		movieFilters.UserID,
+		movieFilters.OnWatchlist,
+		movieFilters.Watched,
+		filters.limit(),
+		filters.offset(),
+	}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -201,6 +325,65 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.Rating.Average,
+			&movie.Rating.Count,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Movies in the trash
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, rating_average, rating_count, deleted_at
+		FROM movies
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), THREE_SECONDS)
+
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.Rating.Average,
+			&movie.Rating.Count,
+			&movie.DeletedAt,
 		)
 
 		if err != nil {
@@ -3,38 +3,180 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// Returned when a change would take the permissions:admin permission away
+// from the user making it, locking them out of the permissions API.
+var ErrAdminLockout = errors.New("change would revoke the permissions:admin permission")
+
+// Permission codes have the form "resource:action". A granted code can use
+// "*" in place of either part, so "movies:*" matches every movies action and
+// "*:read" matches reading any resource.
 type Permissions []string
 
+// actionImplies is the single place where the action hierarchy is set up.
+// Each action implies the ones listed for it, and whatever those imply in
+// turn, so holding "movies:admin" also grants "movies:write" and "movies:read".
+var actionImplies = map[string][]string{
+	"admin": {"write"},
+	"write": {"read"},
+}
+
+// Reports whether any of the granted permissions, patterns included,
+// allows the given code.
 func (p Permissions) Include(code string) bool {
-	return slices.Contains(p, code)
+	resource, action, ok := strings.Cut(code, ":")
+	if !ok {
+		return slices.Contains(p, code)
+	}
+
+	for _, granted := range p {
+		grantedResource, grantedAction, ok := strings.Cut(granted, ":")
+		if !ok {
+			continue
+		}
+
+		if grantedResource != "*" && grantedResource != resource {
+			continue
+		}
+
+		if grantedAction == "*" || actionIncludes(grantedAction, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether the granted action is the wanted one or implies it
+func actionIncludes(granted, wanted string) bool {
+	if granted == wanted {
+		return true
+	}
+
+	for _, implied := range actionImplies[granted] {
+		if actionIncludes(implied, wanted) {
+			return true
+		}
+	}
+
+	return false
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	cache *permissionsCache
 }
 
+func (m PermissionModel) CacheStats() PermissionsCacheStats {
+	return m.cache.stats()
+}
+
+// Returns the permissions granted to the user directly together with the
+// ones that come from the user's roles. Results are cached per user.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
-	query := `
-		SELECT permissions.code
-		FROM permissions
-		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+	permissions, generation, found := m.cache.get(userID)
+	if found {
+		return permissions, nil
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := m.DB.QueryContext(ctx, userPermissionsQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err = scanPermissions(rows)
 	if err != nil {
 		return nil, err
 	}
 
+	m.cache.set(userID, generation, permissions)
+
+	return permissions, nil
+}
+
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidate(userID)
+
+	return nil
+}
+
+// Removes the codes from the permissions granted directly to the user. The
+// change is rolled back with ErrAdminLockout when it would leave actorID,
+// the user making it, without the permissions:admin permission.
+func (m PermissionModel) RemoveForUser(userID, actorID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1
+		AND permission_id IN (SELECT permissions.id FROM permissions WHERE permissions.code = ANY($2))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	err = checkAdminKept(ctx, tx, actorID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidate(userID)
+
+	return nil
+}
+
+// The permissions granted to user $1 directly together with the ones that
+// come from the user's roles
+const userPermissionsQuery = `
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+	WHERE users_permissions.user_id = $1
+	UNION
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN role_permissions ON role_permissions.permission_id = permissions.id
+	INNER JOIN users_roles ON users_roles.role_id = role_permissions.role_id
+	WHERE users_roles.user_id = $1`
+
+func scanPermissions(rows *sql.Rows) (Permissions, error) {
 	defer rows.Close()
 
 	var permissions Permissions
@@ -50,21 +192,67 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		permissions = append(permissions, permission)
 	}
 
-	if err = rows.Close(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+// Recomputes the user's effective permissions inside tx, wildcards and
+// roles included, and fails with ErrAdminLockout when they no longer allow
+// permissions:admin. Call it after a change and before committing.
+func checkAdminKept(ctx context.Context, tx *sql.Tx, userID int64) error {
+	rows, err := tx.QueryContext(ctx, userPermissionsQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := scanPermissions(rows)
+	if err != nil {
+		return err
+	}
+
+	if !permissions.Include("permissions:admin") {
+		return ErrAdminLockout
+	}
+
+	return nil
+}
+
+// Returns every permission code known to the application
+func (m PermissionModel) ListAll() (Permissions, error) {
 	query := `
-		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT code
+		FROM permissions
+		ORDER BY code ASC`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
 }
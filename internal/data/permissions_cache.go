@@ -0,0 +1,174 @@
+package data
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long the permissions of a user are kept in memory. Changes made through
+// the models invalidate the cache straight away, the TTL only bounds how stale
+// it can get when the grants are changed behind our back, by another instance
+// of the API or by hand in psql.
+const permissionsCacheTTL = time.Minute
+
+type permissionsCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// A nil *permissionsCache is valid and caches nothing, so a PermissionModel
+// built without NewModels() keeps working.
+//
+// Every invalidation takes a new value from counter and records it as the
+// generation of the user, or of every user for invalidateAll(). A miss hands
+// out the current generation, and set() drops permissions loaded under an
+// older one: they may have been read before the change that invalidated
+// them was committed.
+type permissionsCache struct {
+	mu            sync.RWMutex
+	entries       map[int64]permissionsCacheEntry
+	counter       uint64
+	generations   map[int64]uint64
+	allGeneration uint64
+	ttl           time.Duration
+	hits          atomic.Int64
+	misses        atomic.Int64
+}
+
+// PermissionsCacheStats is published through expvar
+type PermissionsCacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	Size    int     `json:"size"`
+}
+
+func newPermissionsCache(ttl time.Duration) *permissionsCache {
+	c := &permissionsCache{
+		entries:     make(map[int64]permissionsCacheEntry),
+		generations: make(map[int64]uint64),
+		ttl:         ttl,
+	}
+
+	// background goroutine which removes the expired entries once every
+	// minute, so users who stopped making requests don't stay in memory.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			c.mu.Lock()
+
+			for userID, entry := range c.entries {
+				if time.Now().After(entry.expiry) {
+					delete(c.entries, userID)
+				}
+			}
+
+			c.mu.Unlock()
+		}
+	}()
+
+	return c
+}
+
+// On a miss it returns the generation to hand to set() once the permissions
+// have been loaded.
+func (c *permissionsCache) get(userID int64) (Permissions, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.mu.RLock()
+	entry, found := c.entries[userID]
+	generation := c.generation(userID)
+	c.mu.RUnlock()
+
+	if !found || time.Now().After(entry.expiry) {
+		c.misses.Add(1)
+		return nil, generation, false
+	}
+
+	c.hits.Add(1)
+
+	// Hand out a copy, so callers can't change the cached value
+	return slices.Clone(entry.permissions), generation, true
+}
+
+// Caches the permissions, unless the user was invalidated since get()
+// returned the generation.
+func (c *permissionsCache) set(userID int64, generation uint64, permissions Permissions) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation(userID) != generation {
+		return
+	}
+
+	c.entries[userID] = permissionsCacheEntry{
+		permissions: slices.Clone(permissions),
+		expiry:      time.Now().Add(c.ttl),
+	}
+}
+
+func (c *permissionsCache) invalidate(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counter++
+	c.generations[userID] = c.counter
+	delete(c.entries, userID)
+}
+
+// Used when a change can affect many users, like editing a role
+func (c *permissionsCache) invalidateAll() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The new allGeneration is above every user generation, so those can go
+	c.counter++
+	c.allGeneration = c.counter
+	clear(c.generations)
+	clear(c.entries)
+}
+
+// Must be called with mu held. Both generations come from counter, so the
+// larger one changes whenever either of them does.
+func (c *permissionsCache) generation(userID int64) uint64 {
+	return max(c.generations[userID], c.allGeneration)
+}
+
+func (c *permissionsCache) stats() PermissionsCacheStats {
+	if c == nil {
+		return PermissionsCacheStats{}
+	}
+
+	c.mu.RLock()
+	size := len(c.entries)
+	c.mu.RUnlock()
+
+	stats := PermissionsCacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Size:   size,
+	}
+
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+
+	return stats
+}
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPermissionsCacheDropsStaleSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(c *permissionsCache)
+		cached     bool
+	}{
+		{"no change", func(c *permissionsCache) {}, true},
+		{"user invalidated", func(c *permissionsCache) { c.invalidate(1) }, false},
+		{"everyone invalidated", func(c *permissionsCache) { c.invalidateAll() }, false},
+		{"other user invalidated", func(c *permissionsCache) { c.invalidate(2) }, true},
+	}
+
+	for _, tt := range tests {
+		c := newPermissionsCache(time.Minute)
+
+		// A miss, then a change is committed while the permissions are
+		// being loaded
+		_, generation, found := c.get(1)
+		if found {
+			t.Fatalf("%s: empty cache returned an entry", tt.name)
+		}
+
+		tt.invalidate(c)
+
+		c.set(1, generation, Permissions{"movies:read"})
+
+		permissions, _, found := c.get(1)
+		if found != tt.cached {
+			t.Errorf("%s: got cached %v; want %v", tt.name, found, tt.cached)
+		}
+
+		if found && !slices.Equal(permissions, Permissions{"movies:read"}) {
+			t.Errorf("%s: got %v; want [movies:read]", tt.name, permissions)
+		}
+	}
+}
+
+func TestPermissionsCacheGenerationAfterInvalidateAll(t *testing.T) {
+	c := newPermissionsCache(time.Minute)
+
+	c.invalidate(1)
+
+	_, generation, _ := c.get(1)
+
+	// Clearing the user generations must not bring back an older value
+	c.invalidateAll()
+
+	c.set(1, generation, Permissions{"movies:read"})
+
+	if _, _, found := c.get(1); found {
+		t.Error("stale permissions were cached after invalidateAll")
+	}
+
+	_, generation, _ = c.get(1)
+	c.set(1, generation, Permissions{"movies:read"})
+
+	if _, _, found := c.get(1); !found {
+		t.Error("fresh permissions were not cached")
+	}
+}
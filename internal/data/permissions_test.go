@@ -0,0 +1,62 @@
+package data
+
+import "testing"
+
+func TestPermissionsInclude(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted Permissions
+		code    string
+		want    bool
+	}{
+		{"exact match", Permissions{"movies:read"}, "movies:read", true},
+		{"no permissions", nil, "movies:read", false},
+		{"any resource read", Permissions{"*:read"}, "reviews:read", true},
+		{"any resource read, not write", Permissions{"*:read"}, "reviews:write", false},
+		{"any movies action", Permissions{"movies:*"}, "movies:admin", true},
+		{"everything", Permissions{"*:*"}, "permissions:admin", true},
+		{"admin implies write", Permissions{"movies:admin"}, "movies:write", true},
+		{"admin implies read", Permissions{"movies:admin"}, "movies:read", true},
+		{"write implies read", Permissions{"movies:write"}, "movies:read", true},
+		{"read doesn't imply write", Permissions{"movies:read"}, "movies:write", false},
+		{"write doesn't imply admin", Permissions{"movies:write"}, "movies:admin", false},
+		{"other resource", Permissions{"movies:admin"}, "reviews:read", false},
+		{"other resource wildcard", Permissions{"reviews:*"}, "movies:read", false},
+		{"resource prefix", Permissions{"movie:read"}, "movies:read", false},
+		{"unknown action", Permissions{"movies:admin"}, "movies:delete", false},
+		{"granted code without action", Permissions{"movies"}, "movies:read", false},
+		{"granted bare wildcard", Permissions{"*"}, "movies:read", false},
+		{"wanted code without action", Permissions{"movies:*"}, "movies", false},
+		{"wanted code without action, exact grant", Permissions{"movies"}, "movies", true},
+		{"empty code", Permissions{"*:*"}, "", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.granted.Include(tt.code); got != tt.want {
+			t.Errorf("%s: %v.Include(%q) = %v; want %v", tt.name, tt.granted, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestActionIncludes(t *testing.T) {
+	tests := []struct {
+		granted, wanted string
+		want            bool
+	}{
+		{"read", "read", true},
+		{"write", "read", true},
+		{"admin", "read", true},
+		{"admin", "write", true},
+		{"read", "write", false},
+		{"read", "admin", false},
+		{"write", "admin", false},
+		{"unknown", "read", false},
+		{"read", "unknown", false},
+	}
+
+	for _, tt := range tests {
+		if got := actionIncludes(tt.granted, tt.wanted); got != tt.want {
+			t.Errorf("actionIncludes(%q, %q) = %v; want %v", tt.granted, tt.wanted, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grglucastr/go-greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// Review is the opinion of a user on a movie. Each user can only review a
+// movie once, after that they edit their review.
+type Review struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	MovieID    int64     `json:"movie_id"`
+	UserID     int64     `json:"user_id"`
+	AuthorName string    `json:"author_name,omitzero"`
+	Score      int32     `json:"score"`
+	Body       string    `json:"body,omitzero"`
+	Version    int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Score >= 1 && review.Score <= 10, "score", "must be between 1 and 10")
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// Inserts the review and adds its score to the movie aggregates in the same
+// transaction.
+func (m ReviewModel) Insert(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO reviews (movie_id, user_id, score, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+
+	args := []any{review.MovieID, review.UserID, review.Score, review.Body}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		case err.Error() == `pq: insert or update on table "reviews" violates foreign key constraint "reviews_movie_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID, int64(review.Score), 1)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReviewModel) Get(id int64) (*Review, error) {
+	query := `
+		SELECT r.id, r.created_at, r.updated_at, r.movie_id, r.user_id, u.name, r.score, r.body, r.version
+		FROM reviews r
+		INNER JOIN users u ON u.id = r.user_id
+		INNER JOIN movies mo ON mo.id = r.movie_id
+		WHERE r.id = $1 AND mo.deleted_at IS NULL`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.AuthorName,
+		&review.Score,
+		&review.Body,
+		&review.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), r.id, r.created_at, r.updated_at, r.movie_id, r.user_id, u.name, r.score, r.body, r.version
+		FROM reviews r
+		INNER JOIN users u ON u.id = r.user_id
+		WHERE r.movie_id = $1
+		ORDER BY r.%s %s, r.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	return m.queryReviews(query, filters, movieID, filters.limit(), filters.offset())
+}
+
+// Every review written by a user, for the data export. Reviews of movies in
+// the trash are included, they are still stored until the movie is purged.
+func (m ReviewModel) GetAllForUser(userID int64) ([]*Review, error) {
+	query := `
+		SELECT count(*) OVER(), r.id, r.created_at, r.updated_at, r.movie_id, r.user_id, u.name, r.score, r.body, r.version
+		FROM reviews r
+		INNER JOIN users u ON u.id = r.user_id
+		WHERE r.user_id = $1
+		ORDER BY r.id ASC`
+
+	reviews, _, err := m.queryReviews(query, Filters{}, userID)
+	return reviews, err
+}
+
+func (m ReviewModel) queryReviews(query string, filters Filters, args ...any) ([]*Review, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.AuthorName,
+			&review.Score,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// Saves the new score and body of a review. The previous score is read and
+// locked in the same transaction, so the movie aggregates get the exact
+// difference.
+func (m ReviewModel) Update(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var previousScore int64
+
+	query := `
+		SELECT score
+		FROM reviews
+		WHERE id = $1 AND version = $2
+		FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, review.ID, review.Version).Scan(&previousScore)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE reviews
+		SET score = $1, body = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3
+		RETURNING updated_at, version`
+
+	args := []any{review.Score, review.Body, review.ID}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		return err
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID, int64(review.Score)-previousScore, 0)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Deletes the review and takes its score out of the movie aggregates
+func (m ReviewModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var movieID, score int64
+
+	query := `
+		DELETE FROM reviews
+		WHERE id = $1
+		RETURNING movie_id, score`
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&movieID, &score)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, movieID, -score, -1)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Adds to the running rating sum and count of a movie. It doesn't bump the
+// movie version, a new review isn't an edit of the movie itself.
+func updateMovieRating(ctx context.Context, tx *sql.Tx, movieID, sumDelta, countDelta int64) error {
+	query := `
+		UPDATE movies
+		SET rating_sum = rating_sum + $1, rating_count = rating_count + $2
+		WHERE id = $3`
+
+	_, err := tx.ExecContext(ctx, query, sumDelta, countDelta, movieID)
+	return err
+}
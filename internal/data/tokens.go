@@ -5,14 +5,25 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/grglucastr/go-greenlight/internal/validator"
+	"github.com/lib/pq"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeEmailChange    = "email-change"
+	ScopeEmailRevert    = "email-revert"
+	ScopeInvitation     = "invitation"
+)
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token reused")
 )
 
 type Token struct {
@@ -21,6 +32,22 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
+	IP        string    `json:"-"`
+	Family    string    `json:"-"`
+	Email     string    `json:"-"`
+}
+
+// Session is the public view of a token family. It never exposes the
+// tokens themselves, only the metadata recorded when the user logged in.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  *time.Time `json:"created_at,omitzero"`
+	LastUsedAt *time.Time `json:"last_used_at,omitzero"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent,omitzero"`
+	IP         string     `json:"ip,omitzero"`
+	Current    bool       `json:"current"`
 }
 
 // Notice that we add the provided TTL (time-to-live) duration argument
@@ -58,17 +85,78 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
-	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+// Creates a token carrying an email address. Email change tokens carry the
+// new address waiting to be confirmed, revert tokens the address it replaced.
+func (m TokenModel) NewEmail(userID int64, ttl time.Duration, scope, email string) (*Token, error) {
+	token := generateToken(userID, ttl, scope)
+	token.Email = email
+
+	err := m.Insert(token)
+	return token, err
+}
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+// Every login starts a new token family. The access token and all refresh
+// tokens issued from that login share the family, so revoking a family ends
+// the whole session.
+func generateFamilyToken(userID int64, ttl time.Duration, scope, family, userAgent, ip string) *Token {
+	token := generateToken(userID, ttl, scope)
+	token.Family = family
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	return token
+}
+
+// Creates a short-lived access token and a long-lived refresh token which
+// belong to a new family, in a single transaction.
+func (m TokenModel) NewPair(userID int64, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	family := rand.Text()
+
+	refresh := generateFamilyToken(userID, refreshTTL, ScopeRefresh, family, userAgent, ip)
+	access := generateFamilyToken(userID, accessTTL, ScopeAuthentication, family, userAgent, ip)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer tx.Rollback()
+
+	for _, token := range []*Token{refresh, access} {
+		_, err = tx.ExecContext(ctx, insertTokenQuery, token.insertArgs()...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Creates only the refresh token of a new family. It's used when the access
+// token isn't stored in the database, like signed JWTs.
+func (m TokenModel) NewRefresh(userID int64, ttl time.Duration, userAgent, ip string) (*Token, error) {
+	token := generateFamilyToken(userID, ttl, ScopeRefresh, rand.Text(), userAgent, ip)
+
+	err := m.Insert(token)
+	return token, err
+}
+
+const insertTokenQuery = `
+	INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip, family, email)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''))`
+
+func (t *Token) insertArgs() []any {
+	return []any{t.Hash, t.UserID, t.Expiry, t.Scope, t.UserAgent, t.IP, t.Family, t.Email}
+}
+
+func (m TokenModel) Insert(token *Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, insertTokenQuery, token.insertArgs()...)
 	return err
 }
 
@@ -83,3 +171,325 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// Deletes a token together with every other token of its family
+func (m TokenModel) DeleteFamily(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1
+		OR family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Deletes every token of a family owned by the user
+func (m TokenModel) DeleteFamilyForUser(family string, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, family, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Marks a refresh token as rotated and issues the next tokens of its family
+// in the same transaction, so a failure can't leave the session without a
+// usable refresh token. The access token is only issued for a non-zero
+// accessTTL, signed JWTs aren't stored. Presenting a refresh token that was
+// already rotated means it leaked, so the whole family is revoked and
+// ErrRefreshTokenReused returned.
+func (m TokenModel) Rotate(refreshTokenPlaintext string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	tokenHash := sha256.Sum256([]byte(refreshTokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer tx.Rollback()
+
+	// The rotated_at IS NULL condition makes sure only one request can
+	// rotate a given refresh token, even when two of them race. Tokens
+	// issued before families existed start one now, the rotated token
+	// included, so its reuse revokes the tokens issued from it.
+	query := `
+		UPDATE tokens
+		SET rotated_at = NOW(), family = COALESCE(family, $4)
+		WHERE hash = $1 AND scope = $2 AND expiry > $3 AND rotated_at IS NULL
+		RETURNING user_id, family`
+
+	var userID int64
+	var family string
+
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, time.Now(), rand.Text()).Scan(&userID, &family)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, err
+		}
+
+		return nil, nil, m.revokeReusedFamily(ctx, tx, tokenHash[:])
+	}
+
+	refresh := generateFamilyToken(userID, refreshTTL, ScopeRefresh, family, userAgent, ip)
+
+	_, err = tx.ExecContext(ctx, insertTokenQuery, refresh.insertArgs()...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var access *Token
+
+	if accessTTL > 0 {
+		access = generateFamilyToken(userID, accessTTL, ScopeAuthentication, family, userAgent, ip)
+
+		_, err = tx.ExecContext(ctx, insertTokenQuery, access.insertArgs()...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Called when a refresh token couldn't be rotated. The token is missing,
+// expired or already rotated, only the last case needs the family to be
+// revoked. A token rotated before families existed has none, so every
+// refresh token of its user is revoked instead.
+func (m TokenModel) revokeReusedFamily(ctx context.Context, tx *sql.Tx, tokenHash []byte) error {
+	query := `
+		WITH reused AS (
+			SELECT user_id, family FROM tokens
+			WHERE hash = $1 AND scope = $2 AND rotated_at IS NOT NULL
+		)
+		DELETE FROM tokens
+		USING reused
+		WHERE tokens.family = reused.family
+		OR (reused.family IS NULL AND tokens.user_id = reused.user_id AND tokens.scope = $2)`
+
+	result, err := tx.ExecContext(ctx, query, tokenHash, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// Removes every expired token. Rotated refresh tokens are kept until then,
+// so that presenting one again is still detected as a reuse.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Returns the active sessions of a user, one per token family. The session
+// with the given family is flagged as the current one.
+func (m TokenModel) GetAllSessionsForUser(userID int64, currentFamily string) ([]*Session, error) {
+	query := `
+		SELECT t.id,
+			(SELECT MIN(f.created_at) FROM tokens f WHERE f.family = t.family),
+			t.last_used_at,
+			t.expiry,
+			COALESCE(t.user_agent, ''),
+			COALESCE(t.ip, ''),
+			COALESCE(t.family = NULLIF($3, ''), false)
+		FROM tokens t
+		WHERE t.user_id = $1 AND t.scope = $2 AND t.expiry > NOW() AND t.rotated_at IS NULL
+		ORDER BY t.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeRefresh, currentFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Deletes a single session and every token of its family. The user ID is part
+// of the WHERE clause so a user can never revoke somebody else's session by
+// guessing an ID.
+func (m TokenModel) DeleteSessionForUser(id, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $2
+		AND family = (SELECT family FROM tokens WHERE id = $1 AND user_id = $2 AND scope = $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Returns the family of a token, or an empty string for tokens that don't
+// belong to a session.
+func (m TokenModel) GetFamily(scope, tokenPlaintext string) (string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT COALESCE(family, '')
+		FROM tokens
+		WHERE hash = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var family string
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope).Scan(&family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return family, nil
+}
+
+// Deletes the access and refresh tokens of every session of a user but the
+// one with the given family. An empty family deletes them all.
+func (m TokenModel) DeleteOtherSessionsForUser(userID int64, family string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1
+		AND scope = ANY($2)
+		AND family IS DISTINCT FROM NULLIF($3, '')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scopes := []string{ScopeAuthentication, ScopeRefresh}
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(scopes), family)
+	return err
+}
+
+// Sets the last used time of the families of many tokens in a single statement
+func (m TokenModel) UpdateLastUsed(tokenPlaintexts []string, lastUsedAt time.Time) error {
+	hashes := make([][]byte, len(tokenPlaintexts))
+
+	for i, plaintext := range tokenPlaintexts {
+		hash := sha256.Sum256([]byte(plaintext))
+		hashes[i] = hash[:]
+	}
+
+	query := `
+		UPDATE tokens
+		SET last_used_at = $1
+		WHERE hash = ANY($2)
+		OR family IN (SELECT family FROM tokens WHERE hash = ANY($2))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, lastUsedAt, pq.ByteaArray(hashes))
+	return err
+}
@@ -0,0 +1,300 @@
+package data
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/grglucastr/go-greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// The number of recovery codes handed out when 2FA is enabled
+const recoveryCodeCount = 10
+
+var (
+	ErrNoTOTPKey = errors.New("totp secret is encrypted but no key is configured")
+)
+
+// TOTP holds the two-factor authentication settings of a user. The secret
+// is only enabled once the user proved they can generate codes with it.
+type TOTP struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       []byte
+	Enabled      bool
+	LastUsedStep int64
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// Secrets are encrypted at rest with Cipher when it's set. Without it they're
+// stored in plaintext, and a database leak is enough to generate codes.
+type TOTPModel struct {
+	DB     *sql.DB
+	Cipher cipher.AEAD
+}
+
+// Creates the AES-256-GCM cipher used to encrypt the secrets from a 32 byte key
+func NewTOTPCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("totp key must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Encrypts the secret if there's a cipher. The user ID is bound to the
+// ciphertext, so a secret copied over to another user won't decrypt.
+func (m TOTPModel) seal(userID int64, secret []byte) ([]byte, bool) {
+	if m.Cipher == nil {
+		return secret, false
+	}
+
+	nonce := make([]byte, m.Cipher.NonceSize())
+	rand.Read(nonce)
+
+	return m.Cipher.Seal(nonce, nonce, secret, binary.BigEndian.AppendUint64(nil, uint64(userID))), true
+}
+
+func (m TOTPModel) open(userID int64, stored []byte, encrypted bool) ([]byte, error) {
+	if !encrypted {
+		return stored, nil
+	}
+
+	if m.Cipher == nil {
+		return nil, ErrNoTOTPKey
+	}
+
+	if len(stored) < m.Cipher.NonceSize() {
+		return nil, errors.New("totp secret is too short")
+	}
+
+	nonce, ciphertext := stored[:m.Cipher.NonceSize()], stored[m.Cipher.NonceSize():]
+
+	return m.Cipher.Open(nil, nonce, ciphertext, binary.BigEndian.AppendUint64(nil, uint64(userID)))
+}
+
+func (m TOTPModel) GetForUser(userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, created_at, secret, secret_encrypted, enabled, last_used_step
+		FROM users_totp
+		WHERE user_id = $1`
+
+	var t TOTP
+	var stored []byte
+	var encrypted bool
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&t.UserID,
+		&t.CreatedAt,
+		&stored,
+		&encrypted,
+		&t.Enabled,
+		&t.LastUsedStep,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	t.Secret, err = m.open(userID, stored, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// Stores a new pending secret for the user. An enabled secret is never
+// replaced, it has to be disabled first.
+func (m TOTPModel) SetPendingSecret(userID int64, secret []byte) error {
+	query := `
+		INSERT INTO users_totp (user_id, secret, secret_encrypted)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, secret_encrypted = EXCLUDED.secret_encrypted, created_at = NOW(), last_used_step = 0
+		WHERE users_totp.enabled = false`
+
+	stored, encrypted := m.seal(userID, secret)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, stored, encrypted)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Enables 2FA and generates the recovery codes in the same transaction, so
+// 2FA is never on without a full set of codes. Only their hashes are stored,
+// the plaintext codes returned here can't be shown again.
+func (m TOTPModel) Enable(userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users_totp
+		SET enabled = true
+		WHERE user_id = $1 AND enabled = false`
+
+	result, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		// 16 base32 characters give 80 bits, formatted as xxxx-xxxx-xxxx-xxxx
+		// to make them easier to copy by hand.
+		text := strings.ToLower(rand.Text()[:16])
+		codes[i] = text[0:4] + "-" + text[4:8] + "-" + text[8:12] + "-" + text[12:16]
+
+		hash := sha256.Sum256([]byte(codes[i]))
+		hashes[i] = hash[:]
+	}
+
+	query = `
+		INSERT INTO totp_recovery_codes (user_id, hash)
+		SELECT $1, unnest($2::bytea[])`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.ByteaArray(hashes))
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// Records the time step of an accepted code. It returns false when that step,
+// or a later one, was already used, which means the code is being replayed.
+func (m TOTPModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+		UPDATE users_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Removes the secret and every recovery code of the user
+func (m TOTPModel) DeleteForUser(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Marks a recovery code as used. It returns false when the code doesn't
+// exist or was already used.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+
+	query := `
+		UPDATE totp_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
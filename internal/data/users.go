@@ -1,13 +1,21 @@
 package data
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/grglucastr/go-greenlight/internal/validator"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -24,7 +32,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	Version   int       `json:"version"`
 }
 
 func (u *User) IsAnonymous() bool {
@@ -36,18 +44,63 @@ type password struct {
 	hash      []byte
 }
 
+// Parameters of new argon2id hashes. They follow the OWASP recommendation of
+// 19 MiB of memory, 2 iterations and a single thread. Hashes made with other
+// parameters, or with bcrypt, are upgraded the next time the user logs in.
+const (
+	argon2Memory  uint32 = 19 * 1024
+	argon2Time    uint32 = 2
+	argon2Threads uint8  = 1
+	argon2SaltLen        = 16
+	argon2KeyLen  uint32 = 32
+)
+
+const argon2Prefix = "$argon2id$"
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
-	if err != nil {
-		return err
-	}
+	salt := make([]byte, argon2SaltLen)
+	rand.Read(salt)
+
+	key := argon2.IDKey([]byte(plaintextPassword), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	// Store the hash in the PHC string format, which carries the algorithm and
+	// its parameters, so Matches() knows how to check it later on.
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix,
+		argon2.Version,
+		argon2Memory,
+		argon2Time,
+		argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
 
 	p.plaintext = &plaintextPassword
-	p.hash = hash
+	p.hash = []byte(encoded)
 	return nil
 }
 
+// The algorithm is detected from the stored hash, so argon2id and the older
+// bcrypt hashes can live side by side.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if bytes.HasPrefix(p.hash, []byte(argon2Prefix)) {
+		params, salt, key, err := decodeArgon2Hash(p.hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(plaintextPassword), salt, params.time, params.memory, params.threads, uint32(len(key)))
+
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+
+	// bcrypt silently ignores everything after the 72nd byte, so a longer
+	// password could match a hash made from its first 72 bytes.
+	if len(plaintextPassword) > 72 {
+		return false, nil
+	}
 
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 
@@ -62,6 +115,65 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// Reports whether the hash was made with bcrypt or with argon2id parameters
+// other than the current ones.
+func (p *password) NeedsRehash() bool {
+	if !bytes.HasPrefix(p.hash, []byte(argon2Prefix)) {
+		return true
+	}
+
+	params, _, key, err := decodeArgon2Hash(p.hash)
+	if err != nil {
+		return true
+	}
+
+	return params.memory != argon2Memory ||
+		params.time != argon2Time ||
+		params.threads != argon2Threads ||
+		uint32(len(key)) != argon2KeyLen
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// Decodes a hash like $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+func decodeArgon2Hash(hash []byte) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	// argon2 panics on zero time or threads, don't let a corrupt hash get there
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil || params.memory == 0 || params.time == 0 || params.threads == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	return params, salt, key, nil
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -70,7 +182,7 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= 256, "password", "must not be more than 256 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -93,6 +205,25 @@ type UserModel struct {
 }
 
 func (m UserModel) Insert(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertUser(ctx context.Context, tx *sql.Tx, user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
@@ -100,10 +231,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -116,6 +244,110 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// UserFilters narrows down the users returned by GetAll(). Nil fields
+// aren't filtered on. Email matches any address containing it, ignoring
+// case, with no wildcard characters.
+type UserFilters struct {
+	Email         string
+	Activated     *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+func (m UserModel) GetAll(userFilters UserFilters, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, email, activated, version
+		FROM users
+		WHERE (strpos(lower(email), lower($1)) > 0 OR $1 = '')
+		AND (activated = $2 OR $2 IS NULL)
+		AND (created_at >= $3 OR $3 IS NULL)
+		AND (created_at < $4 OR $4 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		userFilters.Email,
+		userFilters.Activated,
+		userFilters.CreatedAfter,
+		userFilters.CreatedBefore,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, activated, version
@@ -227,3 +459,179 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// Like GetForToken(), but also returns the email address stored on the token
+func (m UserModel) GetForEmailToken(tokenScope, tokenPlaintext string) (*User, string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, tokens.email
+		FROM users
+		INNER JOIN tokens ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3
+		AND tokens.email IS NOT NULL`
+
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+	var email string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&email,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, "", ErrRecordNotFound
+		default:
+			return nil, "", err
+		}
+	}
+
+	return &user, email, nil
+}
+
+// Marks the user to be deleted for good at the given time. Until then the
+// account still exists and logging in again cancels the deletion.
+func (m UserModel) ScheduleDeletion(id int64, at time.Time) error {
+	query := `
+		UPDATE users
+		SET deletion_scheduled_at = $1
+		WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, at, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Returns true when the user actually had a deletion scheduled
+func (m UserModel) CancelDeletion(id int64) (bool, error) {
+	query := `
+		UPDATE users
+		SET deletion_scheduled_at = NULL
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// Hard deletes every user whose grace period is over. Their tokens,
+// permissions and everything else hanging off users go with them through
+// ON DELETE CASCADE. Their review scores are taken out of the movie ratings
+// first, since the cascade would skip that.
+func (m UserModel) DeleteScheduled() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	// Lock the users first, so a deletion cancelled meanwhile either waits
+	// for us or keeps the user out of both statements below
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM users WHERE deletion_scheduled_at <= NOW() FOR UPDATE`)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	var userIDs []int64
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+
+		userIDs = append(userIDs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+
+	// Then their reviews, so none is edited or removed between summing the
+	// scores and deleting the users
+	_, err = tx.ExecContext(ctx, `SELECT id FROM reviews WHERE user_id = ANY($1) FOR UPDATE`, pq.Array(userIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		UPDATE movies
+		SET rating_sum = movies.rating_sum - deleted.sum, rating_count = movies.rating_count - deleted.count
+		FROM (
+			SELECT reviews.movie_id, SUM(reviews.score) AS sum, COUNT(*) AS count
+			FROM reviews
+			WHERE reviews.user_id = ANY($1)
+			GROUP BY reviews.movie_id
+		) AS deleted
+		WHERE movies.id = deleted.movie_id`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(userIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ANY($1)`, pq.Array(userIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordRoundTrip(t *testing.T) {
+	var p password
+
+	err := p.Set("pa55word-correct")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(p.hash), "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("unexpected hash format %q", p.hash)
+	}
+
+	tests := []struct {
+		plaintext string
+		want      bool
+	}{
+		{"pa55word-correct", true},
+		{"pa55word-wrong", false},
+		{"", false},
+		{"pa55word-correct ", false},
+	}
+
+	for _, tt := range tests {
+		got, err := p.Matches(tt.plaintext)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.plaintext, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%q: got %t; want %t", tt.plaintext, got, tt.want)
+		}
+	}
+
+	if p.NeedsRehash() {
+		t.Error("fresh hash needs a rehash")
+	}
+}
+
+func TestPasswordSaltIsRandom(t *testing.T) {
+	var a, b password
+
+	if err := a.Set("pa55word"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Set("pa55word"); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(a.hash) == string(b.hash) {
+		t.Error("two hashes of the same password are equal")
+	}
+}
+
+func TestPasswordMalformedHash(t *testing.T) {
+	tests := []string{
+		"$argon2id$",
+		"$argon2id$v=19$m=19456,t=2,p=1$c2FsdA",
+		"$argon2id$v=18$m=19456,t=2,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=abc,t=2,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=19456,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=19456,t=2,p=0$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=19456,t=2,p=1$not base64!$a2V5a2V5",
+		"$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHQ$",
+		"$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHQ$a2V5a2V5$extra",
+	}
+
+	for _, hash := range tests {
+		p := password{hash: []byte(hash)}
+
+		ok, err := p.Matches("pa55word")
+		if ok || !errors.Is(err, ErrInvalidPasswordHash) {
+			t.Errorf("%q: got %t, %v; want false, ErrInvalidPasswordHash", hash, ok, err)
+		}
+
+		if !p.NeedsRehash() {
+			t.Errorf("%q: doesn't need a rehash", hash)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pa55word"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := password{hash: bcryptHash}
+
+	ok, err := legacy.Matches("pa55word")
+	if err != nil || !ok {
+		t.Fatalf("bcrypt hash: got %t, %v; want true", ok, err)
+	}
+
+	if !legacy.NeedsRehash() {
+		t.Error("bcrypt hash doesn't need a rehash")
+	}
+
+	// The same key and salt with other parameters than the current ones
+	var current password
+	if err := current.Set("pa55word"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want bool
+	}{
+		{"current parameters", "", "", false},
+		{"less memory", "m=19456", "m=4096", true},
+		{"fewer iterations", "t=2", "t=1", true},
+		{"more threads", "p=1", "p=4", true},
+	}
+
+	for _, tt := range tests {
+		p := password{hash: []byte(strings.Replace(string(current.hash), tt.from, tt.to, 1))}
+
+		if got := p.NeedsRehash(); got != tt.want {
+			t.Errorf("%s: got %t; want %t", tt.name, got, tt.want)
+		}
+	}
+
+	// Hashes made with older parameters still match until they're upgraded
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("pa55word"), salt, 1, 4096, 1, argon2KeyLen)
+
+	old := password{hash: []byte(fmt.Sprintf("$argon2id$v=19$m=4096,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	))}
+
+	ok, err = old.Matches("pa55word")
+	if err != nil || !ok {
+		t.Errorf("older parameters: got %t, %v; want true", ok, err)
+	}
+
+	if !old.NeedsRehash() {
+		t.Error("older parameters: doesn't need a rehash")
+	}
+}
@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/grglucastr/go-greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// WatchedEntry records a user watching a movie. The same movie can be
+// logged again every time it's rewatched.
+type WatchedEntry struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"-"`
+	WatchedAt time.Time `json:"watched_at"`
+	Movie     *Movie    `json:"movie,omitzero"`
+}
+
+func ValidateWatchedEntry(v *validator.Validator, entry *WatchedEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+	v.Check(!entry.WatchedAt.After(time.Now()), "watched_at", "must not be in the future")
+}
+
+type WatchedModel struct {
+	DB *sql.DB
+}
+
+// Logs the movie as watched and takes it off the watchlist of the user, in
+// a single transaction.
+func (m WatchedModel) Insert(entry *WatchedEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO watched (user_id, movie_id, watched_at)
+		VALUES ($1, $2, $3)
+		RETURNING id`
+
+	err = tx.QueryRowContext(ctx, query, entry.UserID, entry.MovieID, entry.WatchedAt).Scan(&entry.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "watched" violates foreign key constraint "watched_movie_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM watchlist WHERE user_id = $1 AND movie_id = $2`, entry.UserID, entry.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m WatchedModel) GetAll(userID int64, filters Filters) ([]*WatchedEntry, Metadata, error) {
+	return m.getAll(userID, false, filters)
+}
+
+// Like GetAll, but keeps the entries of movies in the trash, for the data
+// export. They are still stored until the movie is purged.
+func (m WatchedModel) GetAllForExport(userID int64, filters Filters) ([]*WatchedEntry, error) {
+	entries, _, err := m.getAll(userID, true, filters)
+	return entries, err
+}
+
+func (m WatchedModel) getAll(userID int64, includeTrashed bool, filters Filters) ([]*WatchedEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), w.id, w.user_id, w.movie_id, w.watched_at,
+			mo.id, mo.created_at, mo.title, mo.year, mo.runtime, mo.genres, mo.version, mo.rating_average, mo.rating_count
+		FROM watched w
+		INNER JOIN movies mo ON mo.id = w.movie_id
+		WHERE w.user_id = $1 AND (mo.deleted_at IS NULL OR $4)
+		ORDER BY w.%s %s, w.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset(), includeTrashed)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchedEntry{}
+
+	for rows.Next() {
+		entry := WatchedEntry{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.UserID,
+			&entry.MovieID,
+			&entry.WatchedAt,
+			&entry.Movie.ID,
+			&entry.Movie.CreatedAt,
+			&entry.Movie.Title,
+			&entry.Movie.Year,
+			&entry.Movie.Runtime,
+			pq.Array(&entry.Movie.Genres),
+			&entry.Movie.Version,
+			&entry.Movie.Rating.Average,
+			&entry.Movie.Rating.Count,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+func (m WatchedModel) DeleteForUser(id, userID int64) error {
+	query := `
+		DELETE FROM watched
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
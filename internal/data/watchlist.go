@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WatchlistEntry is a movie a user wants to watch
+type WatchlistEntry struct {
+	AddedAt time.Time `json:"added_at"`
+	Movie   *Movie    `json:"movie"`
+}
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Adding a movie that is already on the watchlist does nothing
+func (m WatchlistModel) Add(userID, movieID int64) error {
+	query := `
+		INSERT INTO watchlist (user_id, movie_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "watchlist" violates foreign key constraint "watchlist_movie_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	query := `
+		DELETE FROM watchlist
+		WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m WatchlistModel) GetAll(userID int64, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	return m.getAll(userID, false, filters)
+}
+
+// Like GetAll, but keeps the entries of movies in the trash, for the data
+// export. They are still stored until the movie is purged.
+func (m WatchlistModel) GetAllForExport(userID int64, filters Filters) ([]*WatchlistEntry, error) {
+	entries, _, err := m.getAll(userID, true, filters)
+	return entries, err
+}
+
+func (m WatchlistModel) getAll(userID int64, includeTrashed bool, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), w.added_at,
+			mo.id, mo.created_at, mo.title, mo.year, mo.runtime, mo.genres, mo.version, mo.rating_average, mo.rating_count
+		FROM watchlist w
+		INNER JOIN movies mo ON mo.id = w.movie_id
+		WHERE w.user_id = $1 AND (mo.deleted_at IS NULL OR $4)
+		ORDER BY w.%s %s, mo.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset(), includeTrashed)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		entry := WatchlistEntry{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.AddedAt,
+			&entry.Movie.ID,
+			&entry.Movie.CreatedAt,
+			&entry.Movie.Title,
+			&entry.Movie.Year,
+			&entry.Movie.Runtime,
+			pq.Array(&entry.Movie.Genres),
+			&entry.Movie.Version,
+			&entry.Movie.Rating.Average,
+			&entry.Movie.Rating.Count,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
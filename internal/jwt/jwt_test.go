@@ -0,0 +1,187 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKeySet(t *testing.T) (*KeySet, *Key, *Key) {
+	t.Helper()
+
+	hs, err := NewHS256Key("hs", bytes.Repeat([]byte("s"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed, err := NewEdDSAKey("ed", bytes.Repeat([]byte("e"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := NewKeySet("hs", hs, ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ks, hs, ed
+}
+
+func validClaims() Claims {
+	now := time.Now().Unix()
+
+	return Claims{
+		Subject:     "42",
+		SessionID:   "family",
+		IssuedAt:    now,
+		NotBefore:   now,
+		ExpiresAt:   now + 60,
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+	}
+}
+
+// Builds a token with any header, signed by key when it isn't nil
+func forgeToken(t *testing.T, h header, claims Claims, key *Key) string {
+	t.Helper()
+
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	var signature []byte
+	if key != nil {
+		signature = key.sign([]byte(signingInput))
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	hs, err := NewHS256Key("hs", bytes.Repeat([]byte("s"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed, err := NewEdDSAKey("ed", bytes.Repeat([]byte("e"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, signing := range []string{"hs", "ed"} {
+		ks, err := NewKeySet(signing, hs, ed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := validClaims()
+
+		token, err := ks.Sign(want)
+		if err != nil {
+			t.Fatalf("%s: %v", signing, err)
+		}
+
+		got, err := ks.Verify(token)
+		if err != nil {
+			t.Fatalf("%s: %v", signing, err)
+		}
+
+		if got.Subject != want.Subject || got.SessionID != want.SessionID || got.ExpiresAt != want.ExpiresAt ||
+			got.Activated != want.Activated || len(got.Permissions) != 1 || got.Permissions[0] != "movies:read" {
+			t.Errorf("%s: got %+v; want %+v", signing, got, want)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	ks, hs, ed := newTestKeySet(t)
+
+	valid, err := ks.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ".")
+
+	expired := validClaims()
+	expired.ExpiresAt = time.Now().Unix() - 1
+
+	notYet := validClaims()
+	notYet.NotBefore = time.Now().Unix() + 60
+
+	tamperedClaims := validClaims()
+	tamperedClaims.Permissions = []string{"*:*"}
+	tamperedJSON, err := json.Marshal(tamperedClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip the last byte of the signature
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature[len(signature)-1] ^= 0xff
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"alg none", forgeToken(t, header{Algorithm: "none", Type: "JWT", KeyID: "hs"}, validClaims(), nil), ErrInvalidToken},
+		{"alg of another key", forgeToken(t, header{Algorithm: AlgEdDSA, Type: "JWT", KeyID: "hs"}, validClaims(), ed), ErrInvalidToken},
+		{"kid of another key", forgeToken(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "ed"}, validClaims(), hs), ErrInvalidToken},
+		{"unknown kid", forgeToken(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "gone"}, validClaims(), hs), ErrInvalidToken},
+		{"expired", forgeToken(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "hs"}, expired, hs), ErrExpiredToken},
+		{"not valid yet", forgeToken(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "hs"}, notYet, hs), ErrTokenNotYet},
+		{"tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString(tamperedJSON) + "." + parts[2], ErrInvalidToken},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature), ErrInvalidToken},
+		{"missing signature", parts[0] + "." + parts[1] + ".", ErrInvalidToken},
+		{"two segments", parts[0] + "." + parts[1], ErrInvalidToken},
+		{"four segments", valid + "." + parts[2], ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+		{"not base64", "!!." + parts[1] + "." + parts[2], ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		_, err := ks.Verify(tt.token)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v; want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	hs, err := NewHS256Key("hs", bytes.Repeat([]byte("s"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewKeySet("missing", hs); err == nil {
+		t.Error("unknown signing key: got no error")
+	}
+
+	if _, err := NewKeySet("hs", hs, hs); err == nil {
+		t.Error("duplicate key id: got no error")
+	}
+
+	if _, err := NewHS256Key("short", []byte("too short")); err == nil {
+		t.Error("short HS256 secret: got no error")
+	}
+
+	if _, err := NewEdDSAKey("short", []byte("too short")); err == nil {
+		t.Error("short EdDSA seed: got no error")
+	}
+}
@@ -0,0 +1,111 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"time"
+)
+
+// We use the parameters every authenticator app understands: HMAC-SHA1,
+// 6 digit codes and a 30 second time step.
+const (
+	Digits = 6
+	Period = 30 * time.Second
+)
+
+// Codes from one time step before and after the current one are accepted too,
+// to make up for clock drift and the time it takes to type the code.
+const skew = 1
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns a new random 160 bit secret, the size recommended by RFC 4226
+func NewSecret() []byte {
+	secret := make([]byte, 20)
+	rand.Read(secret)
+	return secret
+}
+
+// Returns the secret the way authenticator apps expect it to be typed in
+func EncodeSecret(secret []byte) string {
+	return b32.EncodeToString(secret)
+}
+
+// Builds the otpauth:// URI that authenticator apps read from a QR code
+func ProvisioningURI(issuer, account string, secret []byte) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+	}
+
+	q := url.Values{}
+	q.Set("secret", EncodeSecret(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period.Seconds())))
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// Returns the time step a moment falls in, as defined by RFC 6238
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Returns the code for the given moment
+func Code(secret []byte, t time.Time) string {
+	return hotp(secret, uint64(Step(t)), Digits, sha1.New)
+}
+
+// Checks a code against the time steps around t. It returns the matching time
+// step, which callers should store and refuse to accept again, so a code
+// can't be replayed while it's still valid.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+
+	for step := current - skew; step <= current+skew; step++ {
+		expected := hotp(secret, uint64(step), Digits, sha1.New)
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// hotp implements the HOTP algorithm of RFC 4226, TOTP is HOTP with the time
+// step as counter.
+func hotp(secret []byte, counter uint64, digits int, h func() hash.Hash) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(h, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, see section 5.3 of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"testing"
+	"time"
+)
+
+// Test vectors from appendix D of RFC 4226
+func TestHOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for counter, code := range want {
+		got := hotp(secret, uint64(counter), 6, sha1.New)
+		if got != code {
+			t.Errorf("counter %d: got %s; want %s", counter, got, code)
+		}
+	}
+}
+
+// Test vectors from appendix B of RFC 6238
+func TestTOTPVectors(t *testing.T) {
+	secrets := map[string][]byte{
+		"SHA1":   []byte("12345678901234567890"),
+		"SHA256": []byte("12345678901234567890123456789012"),
+		"SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+
+	hashes := map[string]func() hash.Hash{
+		"SHA1":   sha1.New,
+		"SHA256": sha256.New,
+		"SHA512": sha512.New,
+	}
+
+	tests := []struct {
+		unix int64
+		mode string
+		code string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+
+		got := hotp(secrets[tt.mode], uint64(step), 8, hashes[tt.mode])
+		if got != tt.code {
+			t.Errorf("%s at %d: got %s; want %s", tt.mode, tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		wantOK bool
+	}{
+		{"current step", now, true},
+		{"previous step", now.Add(-Period), true},
+		{"next step", now.Add(Period), true},
+		{"two steps ago", now.Add(-2 * Period), false},
+		{"two steps ahead", now.Add(2 * Period), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(secret, Code(secret, tt.at), now)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v; want %v", ok, tt.wantOK)
+			}
+
+			if ok && step != Step(tt.at) {
+				t.Errorf("got step %d; want %d", step, Step(tt.at))
+			}
+		})
+	}
+
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("accepted a code with the wrong number of digits")
+	}
+}
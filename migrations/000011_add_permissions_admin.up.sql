@@ -0,0 +1,5 @@
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
+
+INSERT INTO permissions(code)
+VALUES
+    ('permissions:admin');
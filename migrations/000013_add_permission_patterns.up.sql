@@ -0,0 +1,12 @@
+INSERT INTO permissions(code)
+VALUES
+    ('movies:admin'),
+    ('movies:*'),
+    ('*:read')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code IN ('movies:admin', 'movies:*', '*:read')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,12 @@
+INSERT INTO permissions(code)
+VALUES
+    ('users:admin')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'users:admin'
+ON CONFLICT DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at);
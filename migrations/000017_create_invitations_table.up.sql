@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    email text NOT NULL,
+    hash bytea UNIQUE NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    permissions text[] NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS invitations_email_idx ON invitations (email);
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'audit:admin';
+
+DROP TABLE IF EXISTS audit_events;
+
+DROP FUNCTION IF EXISTS audit_events_append_only();
@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE code = 'reviews:write';
+
+DROP INDEX IF EXISTS movies_rating_count_idx;
+DROP INDEX IF EXISTS movies_rating_average_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_average;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_sum;
+
+DROP TABLE IF EXISTS reviews;
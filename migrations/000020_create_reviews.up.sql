@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    score integer NOT NULL,
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT reviews_movie_id_user_id_key UNIQUE (movie_id, user_id),
+    CONSTRAINT reviews_score_check CHECK (score BETWEEN 1 AND 10)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+-- The aggregates are kept as a running sum and count, so concurrent reviews
+-- of the same movie only ever add to them. The average is derived from both.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_sum bigint NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_average double precision
+    GENERATED ALWAYS AS (CASE WHEN rating_count = 0 THEN 0 ELSE rating_sum::double precision / rating_count END) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_rating_average_idx ON movies (rating_average);
+CREATE INDEX IF NOT EXISTS movies_rating_count_idx ON movies (rating_count);
+
+INSERT INTO permissions(code)
+VALUES
+    ('reviews:write')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name IN ('viewer', 'editor', 'admin') AND permissions.code = 'reviews:write'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1 @@
+ALTER TABLE users_totp DROP COLUMN IF EXISTS secret_encrypted;
@@ -0,0 +1,4 @@
+-- Secrets are encrypted with the -totp-key flag when it's set. Rows written
+-- without a key keep secret_encrypted = false and hold the plaintext secret,
+-- turning 2FA off and on again once the key is set encrypts them.
+ALTER TABLE users_totp ADD COLUMN IF NOT EXISTS secret_encrypted bool NOT NULL DEFAULT false;